@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mygrpc/pkg/tlsutil"
+)
+
+// certgen writes a throwaway CA with server and client certificates for
+// trying the TLS flags of the server and client locally:
+//
+//	go run ./cmd/certgen -dir certs
+//	go run ./cmd/server -tls-cert certs/server.pem -tls-key certs/server-key.pem -tls-ca certs/ca.pem -tls-client-auth
+//	go run ./cmd/client -tls-ca certs/ca.pem -tls-cert certs/client.pem -tls-key certs/client-key.pem
+func main() {
+	var (
+		dir      = flag.String("dir", "certs", "output directory")
+		hosts    = flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated names and addresses of the server")
+		client   = flag.String("client", "client", "common name of the client certificate")
+		validFor = flag.Duration("valid-for", 30*24*time.Hour, "validity of the certificates")
+	)
+	flag.Parse()
+
+	files, err := tlsutil.Generate(*dir, strings.Split(*hosts, ","), *client, *validFor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(files.CACert)
+	fmt.Println(files.ServerCert, files.ServerKey)
+	fmt.Println(files.ClientCert, files.ClientKey)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// benchConfig is what the bench command runs. A run stops after Requests
+// calls or after Duration, whichever comes first.
+type benchConfig struct {
+	RPC         string
+	Concurrency int
+	Duration    time.Duration
+	Requests    int
+	// Rate is the calls per second over all workers. Zero runs a closed
+	// loop, each worker calling again as soon as its last call returns.
+	Rate     float64
+	Messages int
+	Name     string
+	Label    string
+	CSVFile  string
+	JSONFile string
+}
+
+// benchResult is the outcome of a run, in the form written to the JSON
+// and CSV result files.
+type benchResult struct {
+	Label       string         `json:"label,omitempty"`
+	RPC         string         `json:"rpc"`
+	Concurrency int            `json:"concurrency"`
+	Rate        float64        `json:"rate"`
+	Calls       int            `json:"calls"`
+	Errors      int            `json:"errors"`
+	Elapsed     time.Duration  `json:"elapsed_ns"`
+	Throughput  float64        `json:"throughput"`
+	P50         time.Duration  `json:"p50_ns"`
+	P90         time.Duration  `json:"p90_ns"`
+	P99         time.Duration  `json:"p99_ns"`
+	Max         time.Duration  `json:"max_ns"`
+	Codes       map[string]int `json:"codes"`
+}
+
+func parseBench(name string, args []string, _ io.Reader, stderr io.Writer) (command, error) {
+	var cfg benchConfig
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.RPC, "rpc", "hello", "call to drive: hello, server-stream, client-stream or bidi")
+	fs.IntVar(&cfg.Concurrency, "c", 10, "number of concurrent workers")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "how long to run; 10s when -n is not set either")
+	fs.IntVar(&cfg.Requests, "n", 0, "number of calls to make; 0 for no limit")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "calls per second over all workers (open loop); 0 calls back to back (closed loop)")
+	fs.IntVar(&cfg.Messages, "messages", 5, "requests sent per client-stream or bidi call")
+	fs.StringVar(&cfg.Name, "name", "bench", "name sent in every request")
+	fs.StringVar(&cfg.Label, "label", "", "label recorded with the results, such as the server version")
+	fs.StringVar(&cfg.CSVFile, "csv", "", "file to append the results to as CSV")
+	fs.StringVar(&cfg.JSONFile, "json", "", "file to append the results to as a JSON line")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError{err}
+	}
+
+	var err error
+	switch {
+	case fs.NArg() > 0:
+		err = fmt.Errorf("%s takes no arguments", name)
+	case benchCalls[cfg.RPC] == nil:
+		err = fmt.Errorf("unknown rpc %q", cfg.RPC)
+	case cfg.Concurrency < 1:
+		err = errors.New("-c must be at least 1")
+	case cfg.Requests < 0 || cfg.Duration < 0 || cfg.Rate < 0:
+		err = errors.New("-n, -duration and -rate must not be negative")
+	case cfg.Messages < 1:
+		err = errors.New("-messages must be at least 1")
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		fs.Usage()
+		return nil, usageError{err}
+	}
+	if cfg.Requests == 0 && cfg.Duration == 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	return func(c *cli) error { return c.bench(cfg) }, nil
+}
+
+// benchCalls make one call of each shape and wait for it to finish.
+var benchCalls = map[string]func(c *cli, cfg benchConfig) error{
+	"hello": func(c *cli, cfg benchConfig) error {
+		ctx, cancel := c.context()
+		defer cancel()
+		_, err := c.client.Hello(ctx, &hellopb.HelloRequest{Name: cfg.Name})
+		return err
+	},
+	"server-stream": func(c *cli, cfg benchConfig) error {
+		ctx, cancel := c.context()
+		defer cancel()
+		stream, err := c.client.HelloServerStream(ctx, &hellopb.HelloRequest{Name: cfg.Name})
+		if err != nil {
+			return err
+		}
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return ignoreEOF(err)
+			}
+		}
+	},
+	"client-stream": func(c *cli, cfg benchConfig) error {
+		ctx, cancel := c.context()
+		defer cancel()
+		stream, err := c.client.HelloClientStream(ctx)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < cfg.Messages; i++ {
+			if err := stream.Send(&hellopb.HelloRequest{Name: cfg.Name}); err != nil {
+				break // CloseAndRecv returns the status
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	},
+	// bidi waits for each reply before sending the next request, so the
+	// latency covers cfg.Messages round trips
+	"bidi": func(c *cli, cfg benchConfig) error {
+		ctx, cancel := c.context()
+		defer cancel()
+		stream, err := c.client.HelloBiStreams(ctx)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < cfg.Messages; i++ {
+			if err := stream.Send(&hellopb.HelloRequest{Name: cfg.Name}); err != nil {
+				break // Recv returns the status
+			}
+			if _, err := stream.Recv(); err != nil {
+				return ignoreEOF(err)
+			}
+		}
+		if err := stream.CloseSend(); err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return ignoreEOF(err)
+	},
+}
+
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// bench runs cfg and reports the result. Failed calls are counted by code
+// rather than failing the command.
+func (c *cli) bench(cfg benchConfig) error {
+	res := runBench(c, cfg)
+	c.out.bench(res)
+	if cfg.CSVFile != "" {
+		if err := appendCSV(cfg.CSVFile, res); err != nil {
+			return err
+		}
+	}
+	if cfg.JSONFile != "" {
+		if err := appendJSON(cfg.JSONFile, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runBench(c *cli, cfg benchConfig) *benchResult {
+	call := benchCalls[cfg.RPC]
+
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.Rate)
+	}
+	start := time.Now()
+	var end time.Time
+	if cfg.Duration > 0 {
+		end = start.Add(cfg.Duration)
+	}
+
+	var (
+		next      int64 // index of the next call over all workers
+		mu        sync.Mutex
+		latencies []time.Duration
+		codeCount = map[codes.Code]int{}
+		wg        sync.WaitGroup
+	)
+	for w := 0; w < cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			localCodes := map[codes.Code]int{}
+			for {
+				i := atomic.AddInt64(&next, 1) - 1
+				if cfg.Requests > 0 && i >= int64(cfg.Requests) {
+					break
+				}
+				// In an open loop call i is due at a fixed time and its
+				// latency counts from then, so a slow server is charged
+				// for the calls it delayed, not only for the ones it
+				// served.
+				due := time.Now()
+				if interval > 0 {
+					due = start.Add(time.Duration(i) * interval)
+				}
+				if !end.IsZero() && !due.Before(end) {
+					break
+				}
+				if wait := time.Until(due); wait > 0 {
+					time.Sleep(wait)
+				}
+				err := call(c, cfg)
+				local = append(local, time.Since(due))
+				localCodes[status.Code(err)]++
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			for code, n := range localCodes {
+				codeCount[code] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res := &benchResult{
+		Label:       cfg.Label,
+		RPC:         cfg.RPC,
+		Concurrency: cfg.Concurrency,
+		Rate:        cfg.Rate,
+		Calls:       len(latencies),
+		Elapsed:     elapsed,
+		Throughput:  float64(len(latencies)) / elapsed.Seconds(),
+		P50:         percentile(latencies, 50),
+		P90:         percentile(latencies, 90),
+		P99:         percentile(latencies, 99),
+		Max:         percentile(latencies, 100),
+		Codes:       map[string]int{},
+	}
+	for code, n := range codeCount {
+		res.Codes[code.String()] = n
+		if code != codes.OK {
+			res.Errors += n
+		}
+	}
+	return res
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// csvHeader names the columns of a CSV result row. Codes go in one column
+// as code=count pairs, so runs with different errors share the header.
+var csvHeader = []string{"label", "rpc", "concurrency", "rate", "calls", "errors", "elapsed_s", "throughput", "p50_ms", "p90_ms", "p99_ms", "max_ms", "codes"}
+
+func (r *benchResult) csvRecord() []string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	return []string{
+		r.Label,
+		r.RPC,
+		strconv.Itoa(r.Concurrency),
+		strconv.FormatFloat(r.Rate, 'f', -1, 64),
+		strconv.Itoa(r.Calls),
+		strconv.Itoa(r.Errors),
+		strconv.FormatFloat(r.Elapsed.Seconds(), 'f', 3, 64),
+		strconv.FormatFloat(r.Throughput, 'f', 1, 64),
+		ms(r.P50),
+		ms(r.P90),
+		ms(r.P99),
+		ms(r.Max),
+		r.codeList(),
+	}
+}
+
+// codeList lists the calls per code as "OK=98 Unavailable=2", most
+// frequent first.
+func (r *benchResult) codeList() string {
+	names := make([]string, 0, len(r.Codes))
+	for name := range r.Codes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if r.Codes[names[i]] != r.Codes[names[j]] {
+			return r.Codes[names[i]] > r.Codes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Itoa(r.Codes[name])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// appendCSV adds r to path, writing the header first when the file is new
+// or empty, so that runs against different servers collect in one table.
+func appendCSV(path string, r *benchResult) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(csvHeader)
+	}
+	w.Write(r.csvRecord())
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// appendJSON adds r to path as one line of JSON.
+func appendJSON(path string, r *benchResult) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Close()
+}
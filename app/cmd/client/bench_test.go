@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/grpctest"
+)
+
+func TestBench(t *testing.T) {
+	addr, _ := startTestServer(t)
+
+	for _, rpc := range []string{"hello", "server-stream", "client-stream", "bidi"} {
+		t.Run(rpc, func(t *testing.T) {
+			got := runClient("", "-addr", addr, "-output", "json", "bench", "-rpc", rpc, "-c", "4", "-n", "20")
+			var res benchResult
+			if err := json.Unmarshal([]byte(got.stdout), &res); err != nil {
+				t.Fatalf("exit %d, stdout %q: %v\nstderr:\n%s", got.code, got.stdout, err, got.stderr)
+			}
+			if res.Calls != 20 || res.Errors != 0 || res.Codes["OK"] != 20 {
+				t.Errorf("result = %+v, want 20 successful calls", res)
+			}
+			if res.P50 <= 0 || res.P50 > res.P90 || res.P90 > res.P99 || res.P99 > res.Max {
+				t.Errorf("latencies out of order: %+v", res)
+			}
+		})
+	}
+}
+
+func TestBenchErrors(t *testing.T) {
+	addr, _ := startTestServer(t)
+	got := runClient("", "-addr", addr, "-output", "json", "bench", "-name", "", "-n", "5")
+	var res benchResult
+	if err := json.Unmarshal([]byte(got.stdout), &res); err != nil {
+		t.Fatal(err)
+	}
+	if got.code != 0 || res.Errors != 5 || res.Codes["InvalidArgument"] != 5 {
+		t.Errorf("exit %d, result %+v, want 5 InvalidArgument", got.code, res)
+	}
+}
+
+func TestBenchNoRetries(t *testing.T) {
+	var calls int32
+	addr := grpctest.Listen(t, grpctest.Greeter{Check: func(context.Context, string) error {
+		atomic.AddInt32(&calls, 1)
+		return status.Error(codes.Unavailable, "down")
+	}})
+	got := runClient("", "-addr", addr, "-output", "json", "bench", "-n", "5", "-c", "1")
+	var res benchResult
+	if err := json.Unmarshal([]byte(got.stdout), &res); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 5 || res.Codes["Unavailable"] != 5 {
+		t.Errorf("%d calls served, result %+v, want 5 single attempts", n, res)
+	}
+}
+
+func TestBenchRate(t *testing.T) {
+	addr, _ := startTestServer(t)
+	start := time.Now()
+	got := runClient("", "-addr", addr, "bench", "-rate", "100", "-n", "11", "-c", "2")
+	// calls are due every 10ms from the start, the last one at 100ms
+	if d := time.Since(start); got.code != 0 || d < 100*time.Millisecond {
+		t.Errorf("exit %d after %s, want at least 100ms\n%s%s", got.code, d, got.stdout, got.stderr)
+	}
+}
+
+func TestBenchDuration(t *testing.T) {
+	addr, _ := startTestServer(t)
+	start := time.Now()
+	got := runClient("", "-addr", addr, "bench", "-duration", "100ms", "-c", "2")
+	if d := time.Since(start); got.code != 0 || d < 100*time.Millisecond || d > 5*time.Second {
+		t.Errorf("exit %d after %s, want about 100ms\n%s%s", got.code, d, got.stdout, got.stderr)
+	}
+}
+
+func TestBenchResultFiles(t *testing.T) {
+	addr, _ := startTestServer(t)
+	dir := t.TempDir()
+	csvFile, jsonFile := filepath.Join(dir, "results.csv"), filepath.Join(dir, "results.json")
+
+	for _, label := range []string{"v1", "v2"} {
+		got := runClient("", "-addr", addr, "bench", "-n", "3", "-label", label, "-csv", csvFile, "-json", jsonFile)
+		if got.code != 0 {
+			t.Fatalf("exit %d: %s", got.code, got.stderr)
+		}
+	}
+
+	f, err := os.Open(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "label" || rows[1][0] != "v1" || rows[2][0] != "v2" || rows[2][len(csvHeader)-1] != "OK=3" {
+		t.Errorf("csv = %q, want a header and one row per run", rows)
+	}
+
+	b, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for _, label := range []string{"v1", "v2"} {
+		var res benchResult
+		if err := dec.Decode(&res); err != nil || res.Label != label {
+			t.Errorf("json run %s: %+v, %v", label, res, err)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{{50, 50}, {90, 90}, {99, 99}, {100, 100}, {0, 1}}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v", got)
+	}
+}
+
+func TestBenchUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"bench", "-rpc", "ping"},
+		{"bench", "-c", "0"},
+		{"bench", "extra"},
+	} {
+		if got := runClient("", args...); got.code != 2 {
+			t.Errorf("%q: exit %d, want 2", args, got.code)
+		}
+	}
+}
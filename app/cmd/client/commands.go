@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// command runs against the connection once the client has dialed.
+type command func(c *cli) error
+
+// commands parse their arguments into a command before the client dials,
+// so that usage errors fail fast.
+var commands = map[string]func(name string, args []string, stdin io.Reader, stderr io.Writer) (command, error){
+	"hello":         withNames((*cli).hello),
+	"server-stream": withNames((*cli).serverStream),
+	"client-stream": withNames((*cli).clientStream),
+	"bidi":          withNames((*cli).bidi),
+	"health":        parseHealth,
+	"bench":         parseBench,
+}
+
+// withNames turns a call taking names into a command reading them with
+// readNames.
+func withNames(call func(c *cli, names []string) error) func(string, []string, io.Reader, io.Writer) (command, error) {
+	return func(name string, args []string, stdin io.Reader, stderr io.Writer) (command, error) {
+		names, err := readNames(name, args, stdin, stderr)
+		if err != nil {
+			return nil, err
+		}
+		return func(c *cli) error { return call(c, names) }, nil
+	}
+}
+
+func parseHealth(name string, args []string, _ io.Reader, stderr io.Writer) (command, error) {
+	if len(args) > 1 {
+		err := fmt.Errorf("%s takes at most one service name", name)
+		fmt.Fprintln(stderr, err)
+		return nil, usageError{err}
+	}
+	var service string
+	if len(args) > 0 {
+		service = args[0]
+	}
+	return func(c *cli) error { return c.checkHealth(service) }, nil
+}
+
+// cli runs the commands against one connection.
+type cli struct {
+	client  hellopb.GreetingServiceClient
+	health  healthpb.HealthClient
+	out     printer
+	timeout time.Duration
+}
+
+// context bounds a single call, or a whole stream, by the -timeout flag.
+func (c *cli) context() (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+func (c *cli) hello(names []string) error {
+	for _, name := range names {
+		if err := c.helloOne(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) helloOne(name string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var header, trailer metadata.MD
+	res, err := c.client.Hello(ctx, &hellopb.HelloRequest{Name: name}, grpc.Header(&header), grpc.Trailer(&trailer))
+	c.out.metadata("header", header)
+	defer c.out.metadata("trailer", trailer)
+	if err != nil {
+		return err
+	}
+	c.out.response(res)
+	return nil
+}
+
+func (c *cli) serverStream(names []string) error {
+	for _, name := range names {
+		if err := c.serverStreamOne(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) serverStreamOne(name string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	stream, err := c.client.HelloServerStream(ctx, &hellopb.HelloRequest{Name: name})
+	if err != nil {
+		return err
+	}
+	return c.receive(stream)
+}
+
+func (c *cli) clientStream(names []string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	stream, err := c.client.HelloClientStream(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		// io.EOF means the server has ended the call; CloseAndRecv
+		// returns its status
+		if err := stream.Send(&hellopb.HelloRequest{Name: name}); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			break
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if header, herr := stream.Header(); herr == nil {
+		c.out.metadata("header", header)
+	}
+	defer c.out.metadata("trailer", stream.Trailer())
+	if err != nil {
+		return err
+	}
+	c.out.response(res)
+	return nil
+}
+
+func (c *cli) bidi(names []string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	stream, err := c.client.HelloBiStreams(ctx)
+	if err != nil {
+		return err
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		for _, name := range names {
+			if err := stream.Send(&hellopb.HelloRequest{Name: name}); err != nil {
+				// on io.EOF the server has ended the call and Recv
+				// returns its status
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				sent <- err
+				return
+			}
+		}
+		sent <- stream.CloseSend()
+	}()
+
+	if err := c.receive(stream); err != nil {
+		return err
+	}
+	return <-sent
+}
+
+// receiver is the receiving side of the server and bidirectional streams.
+type receiver interface {
+	Header() (metadata.MD, error)
+	Trailer() metadata.MD
+	Recv() (*hellopb.HelloResponse, error)
+}
+
+// receive prints every reply of stream until it ends, between its header
+// and trailer.
+func (c *cli) receive(stream receiver) error {
+	if header, err := stream.Header(); err == nil {
+		c.out.metadata("header", header)
+	}
+	defer func() { c.out.metadata("trailer", stream.Trailer()) }()
+
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.out.response(res)
+	}
+}
+
+func (c *cli) checkHealth(service string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	res, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	c.out.health(res)
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return errNotServing
+	}
+	return nil
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// usageError is a mistake in the command line, already reported along with
+// the usage, as opposed to a failure to read the names.
+type usageError struct{ error }
+
+// readNames parses the flags of the command cmd and collects the names it
+// sends, in the order -name, -file, arguments. With none of them it reads
+// stdin. Blank lines in files are skipped.
+func readNames(cmd string, args []string, stdin io.Reader, stderr io.Writer) ([]string, error) {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var names stringList
+	fs.Var(&names, "name", "name to send; repeatable")
+	file := fs.String("file", "", "file with one name per line, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return nil, usageError{err}
+	}
+
+	if *file == "" && len(names) == 0 && fs.NArg() == 0 {
+		*file = "-"
+	}
+	if *file != "" {
+		r := stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" {
+				names = append(names, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	names = append(names, fs.Args()...)
+
+	if len(names) == 0 {
+		err := fmt.Errorf("%s: no names given", cmd)
+		fmt.Fprintln(stderr, err)
+		fs.Usage()
+		return nil, usageError{err}
+	}
+	return names, nil
+}
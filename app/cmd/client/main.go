@@ -1,262 +1,239 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"os"
-	"log"
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"os"
+	"strings"
+	"time"
+
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/status"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	_ "google.golang.org/grpc/health" // health checks of each backend
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/pkg/auth"
 	hellopb "mygrpc/pkg/grpc"
+	"mygrpc/pkg/lb"
+	"mygrpc/pkg/retry"
+	"mygrpc/pkg/tlsutil"
+	"mygrpc/pkg/tracing"
 )
 
-var (
-	scanner *bufio.Scanner
-	client hellopb.GreetingServiceClient
-)
+const usage = `usage: client [flags] <command> [command flags] [names...]
+
+commands:
+  hello          one Hello call per name
+  server-stream  one HelloServerStream per name, printing every reply
+  client-stream  one HelloClientStream sending all the names
+  bidi           one HelloBiStreams sending all the names
+  health         check the server, or the service given as argument
+  bench          load the server with one kind of call and report
+                 throughput and latency; see client bench -h
 
-func Hello() {
-	fmt.Println("Please enter your name.")
-	scanner.Scan()
-	name := scanner.Text()
+Names come from -name, -file (- for stdin) and the arguments; with none of
+them they are read from stdin, one per line.
 
-	req := &hellopb.HelloRequest{
-		Name: name,
+The exit status is 0 on success (for bench, on finishing the run), 1 on a
+local error or an unhealthy server, 2 on a usage error, and 64 plus the
+gRPC status code when a call fails, so InvalidArgument exits with 67 and
+Unavailable, which is also reported when the server cannot be reached
+within -dial-timeout, with 78.
+
+Hello is retried up to -max-attempts times while the server is unavailable
+or exhausted, except by bench, which measures single attempts; -v shows
+the retries and the retry-attempts trailer.
+`
+
+// exitCodeBase is added to the gRPC status code of a failed call to get
+// the exit status, keeping clear of the codes shells and flag use.
+const exitCodeBase = 64
+
+var errNotServing = errors.New("not serving")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage, "\nflags:\n")
+		fs.PrintDefaults()
 	}
-	ctx := context.Background()
-	md := metadata.New(map[string]string{"type": "unary", "from": "client"})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-	// res, err := client.Hello(context.Background(), req)
-	var header, trailer metadata.MD
-	// res, err := client.Hello(ctx, req)
-	res, err := client.Hello(ctx, req, grpc.Header(&header), grpc.Trailer(&trailer))
-	if err != nil {
-		// fmt.Println(err)
-		if stat, ok := status.FromError(err); ok {
-			fmt.Printf("code: %s\n", stat.Code())
-			fmt.Printf("message: %s\n", stat.Message())
-			fmt.Printf("details: %s\n", stat.Details())
-		} else {
-			fmt.Println(err)
+	addr := fs.String("addr", "localhost:50051", "address of the server, a comma-separated list of them, or a dns:/// or file:/// target")
+	lbPolicy := fs.String("lb", "", "load balancing policy: "+strings.Join(lb.Policies, ", ")+"; round_robin unless the service config says otherwise")
+	timeout := fs.Duration("timeout", 10*time.Second, "deadline of each call, or of each stream; 0 for none")
+	format := fs.String("output", "text", "output format: text or json")
+	verbose := fs.Bool("v", false, "also print headers, trailers, latencies and retries to stderr")
+	dialTimeout := fs.Duration("dial-timeout", 5*time.Second, "how long to wait for the connection")
+	serviceConfig := fs.String("service-config", "", "file with the service config to use instead of the default")
+	maxAttempts := fs.Int("max-attempts", retry.DefaultPolicy.MaxAttempts, "attempts per idempotent unary call; 1 disables retries")
+	var tlsConfig tlsutil.Config
+	useTLS := fs.Bool("tls", false, "connect over TLS; implied by the other -tls flags")
+	fs.StringVar(&tlsConfig.CAFile, "tls-ca", "", "CA that signs the server certificate; the system roots when empty")
+	fs.StringVar(&tlsConfig.CertFile, "tls-cert", "", "client certificate for servers that require one")
+	fs.StringVar(&tlsConfig.KeyFile, "tls-key", "", "client private key")
+	fs.StringVar(&tlsConfig.ServerName, "tls-server-name", "", "name to verify the server certificate against")
+	var traceConfig tracing.Config
+	fs.StringVar(&traceConfig.Exporter, "trace-exporter", "none", "where spans go: none, stdout or otlp")
+	fs.StringVar(&traceConfig.Endpoint, "trace-endpoint", "", "host:port of the OTLP/HTTP collector")
+	fs.BoolVar(&traceConfig.Insecure, "trace-insecure", false, "send spans to the collector without TLS")
+	fs.Float64Var(&traceConfig.SampleRatio, "trace-sample-ratio", 1, "share of traces recorded")
+	token := fs.String("token", "", "bearer token sent with every call")
+	tokenFile := fs.String("token-file", "", "file holding the bearer token")
+	insecureToken := fs.Bool("insecure-token", false, "allow sending the bearer token without TLS, e.g. to a local server")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
 		}
-	} else {
-		fmt.Println(header)
-		fmt.Println(trailer)
-		fmt.Println(res.GetMessage())
+		return 2
 	}
-}
-
-func HelloServerStream() {
-	fmt.Println("Please enter your name.")
-	scanner.Scan()
-	name := scanner.Text()
 
-	req := &hellopb.HelloRequest{
-		Name: name,
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
 	}
-	stream, err := client.HelloServerStream(context.Background(), req)
+	name, args := fs.Arg(0), fs.Args()[1:]
+	parse, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", name)
+		fs.Usage()
+		return 2
+	}
+	out, err := newPrinter(*format, stdout, stderr, *verbose)
 	if err != nil {
-		fmt.Println(err)
-		return
+		fmt.Fprintln(stderr, err)
+		return 2
 	}
-
-	for {
-		res, err := stream.Recv()
-		if errors.Is(err, io.EOF) {
-			fmt.Println("all th responses have already received.")
-			break
+	cmd, err := parse(name, args, stdin, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
 		}
-
-		if err != nil {
-			fmt.Println(err)
+		if errors.As(err, new(usageError)) {
+			return 2
 		}
-		fmt.Println(res)
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
-}
-
-func HelloClientStream() {
-	stream, err := client.HelloClientStream(context.Background())
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	sendCount := 5
-	fmt.Printf("Please enter %d names.\n", sendCount)
-	for i := 0; i < sendCount; i++ {
-		scanner.Scan()
-		name := scanner.Text()
 
-		if err := stream.Send(&hellopb.HelloRequest{
-			Name: name,
-		}); err != nil {
-			fmt.Println(err)
-			return
+	var creds credentials.TransportCredentials = insecure.NewCredentials()
+	secure := *useTLS || tlsConfig.CAFile != "" || tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" || tlsConfig.ServerName != ""
+	if secure {
+		if creds, err = tlsutil.ClientCredentials(tlsConfig); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
 		}
 	}
 
-	res, err := stream.CloseAndRecv()
+	tp, shutdownTracing, err := tracing.NewProvider(context.Background(), "greeting-client", traceConfig)
 	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println(res.GetMessage())
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
-}
+	defer shutdownTracing(context.Background())
 
-func HelloBiStreams() {
-	ctx := context.Background()
-	md := metadata.New(map[string]string{"type": "stream", "from": "client"})
-	ctx = metadata.NewOutgoingContext(ctx, md)
-	// stream, err := client.HelloBiStreams(context.Background())
-	stream, err := client.HelloBiStreams(ctx)
-	if err != nil {
-		fmt.Println(err)
-		return
+	policy := retry.DefaultPolicy
+	policy.MaxAttempts = *maxAttempts
+	if name == "bench" {
+		// retries and their backoff would be timed as one slow call
+		policy.MaxAttempts = 1
 	}
-
-	sendNum := 5
-	fmt.Printf("Please enter %d names.\n", sendNum)
-
-	var sendEnd, recvEnd bool
-	sendCount := 0
-	for !(sendEnd && recvEnd) {
-		if !sendEnd {
-			scanner.Scan()
-			name := scanner.Text()
-
-			sendCount++
-			if err := stream.Send(&hellopb.HelloRequest{
-				Name: name,
-			}); err != nil {
-				fmt.Println(err)
-				sendEnd = true
-			}
-
-			if sendCount == sendNum {
-				sendEnd = true
-				if err := stream.CloseSend(); err != nil {
-					fmt.Println(err)
-				}
-			}
+	if *verbose {
+		policy.OnRetry = func(method string, attempt int, err error, delay time.Duration) {
+			fmt.Fprintf(stderr, "retry: %s attempt %d failed with %s, retrying in %s\n", method, attempt, status.Code(err), delay)
 		}
-
-		var headerMD metadata.MD
-		if !recvEnd {
-			if headerMD == nil {
-				headerMD, err = stream.Header()
-				if err != nil {
-					fmt.Println(err)
-				} else {
-					fmt.Println(headerMD)
-				}
-			}
-
-			if res, err := stream.Recv(); err != nil {
-				if !errors.Is(err, io.EOF) {
-					fmt.Println(err)
-				}
-				recvEnd = true
-			} else {
-				fmt.Println(res.GetMessage())
-			}
+	}
+	config := defaultServiceConfig
+	if *serviceConfig != "" {
+		b, err := os.ReadFile(*serviceConfig)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
 		}
+		config = string(b)
 	}
-
-	trailerMD := stream.Trailer()
-	fmt.Println(trailerMD)
-}
-
-func myUnaryClientInteceptor1(ctx context.Context, method string, req, res interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	fmt.Println("[pre] my unary client interceptor 1", method, req)
-	err := invoker(ctx, method, req, res, cc, opts...)
-	fmt.Println("[post] my unary client interceptor 1", res)
-	return err
-}
-
-func myStreamClientInteceptor1(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	log.Println("[pre] my stream client interceptor 1", method)
-
-	stream, err := streamer(ctx, desc, cc, method, opts...)
-	return &myClientStreamWrapper1{stream}, err
-}
-
-type myClientStreamWrapper1 struct {
-	grpc.ClientStream
-}
-
-func (s *myClientStreamWrapper1) SendMsg(m interface{}) error {
-	log.Println("[pre message] my stream client interceptor 1: ", m)
-	return s.ClientStream.SendMsg(m)
-}
-
-func (s *myClientStreamWrapper1) RecvMsg(m interface{}) error {
-	err := s.ClientStream.RecvMsg(m)
-
-	if !errors.Is(err, io.EOF) {
-		log.Println("[post message] my stream client interceptor 1: ", m)
+	if *lbPolicy != "" {
+		if config, err = lb.WithPolicy(config, *lbPolicy); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
 	}
-	return err
-}
-
-func (s *myClientStreamWrapper1) CloseSend() error {
-	err := s.ClientStream.CloseSend()
-
-	log.Println("[post] my stream client interceptor 1")
-	return err
-}
 
-func main() {
-	fmt.Println("Start gRPC client.")
-
-	scanner = bufio.NewScanner(os.Stdin)
-
-	address := "localhost:50051"
-	conn, err := grpc.Dial(
-		address,
-		grpc.WithStreamInterceptor(myStreamClientInteceptor1),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	opts := []grpc.DialOption{
+		// retries sit inside tracing so that one span covers every attempt
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(tp), retry.UnaryClientInterceptor(policy, idempotentMethods...)),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor(tp)),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(config),
 		grpc.WithBlock(),
-	)
+		grpc.WithReturnConnectionError(),
+	}
+	if name == "health" {
+		// the probe must reach a server its own health service reports
+		// down, which the channel would otherwise leave out
+		opts = append(opts, grpc.WithDisableHealthCheck())
+	}
+	if *tokenFile != "" {
+		b, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		*token = strings.TrimSpace(string(b))
+	}
+	if *token != "" {
+		if !secure && !*insecureToken {
+			fmt.Fprintln(stderr, "refusing to send the token without TLS; use -tls, or -insecure-token if you mean it")
+			return 2
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.BearerToken{Token: *token, AllowInsecure: *insecureToken}))
+	}
 
+	dialCtx, cancel := context.WithTimeout(context.Background(), *dialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, lb.Target(*addr), opts...)
 	if err != nil {
-		log.Fatal("Connection failed!")
-		return
+		if dialCtx.Err() == nil {
+			// a bad option, such as an invalid service config
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		err = status.Errorf(codes.Unavailable, "connecting to %s: %v", *addr, err)
+		out.error(err)
+		return exitCode(err)
 	}
 	defer conn.Close()
 
-	client = hellopb.NewGreetingServiceClient(conn)
-
-	for {
-		fmt.Println("1: send Request")
-		fmt.Println("2: HelloServerStream")
-		fmt.Println("3: HelloClientStream")
-		fmt.Println("4: HelloBiStream")
-		fmt.Println("5: exit")
-		fmt.Print("please enter >")
-
-		scanner.Scan()
-		in := scanner.Text()
-
-		switch in {
-		case "1":
-			Hello()
-		case "2":
-			HelloServerStream()
-		case "3":
-			HelloClientStream()
-		case "4":
-			HelloBiStreams()
-		case "5":
-			fmt.Println("bye.")
-			goto M
-		}
+	c := &cli{
+		client:  hellopb.NewGreetingServiceClient(conn),
+		health:  healthpb.NewHealthClient(conn),
+		out:     out,
+		timeout: *timeout,
 	}
-M:
+	err = cmd(c)
+	if err != nil && !errors.Is(err, errNotServing) {
+		out.error(err)
+	}
+	return exitCode(err)
+}
 
+// exitCode maps the result of a command to the exit status described in
+// usage.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if stat, ok := status.FromError(err); ok {
+		return exitCodeBase + int(stat.Code())
+	}
+	return 1
 }
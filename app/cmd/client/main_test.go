@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// greeter rejects empty names and waits for its deadline on "slow".
+type greeter struct {
+	grpctest.Greeter
+}
+
+func newGreeter() greeter {
+	return greeter{grpctest.Greeter{Check: func(ctx context.Context, name string) error {
+		if name == "slow" {
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return nil
+	}}}
+}
+
+func (g greeter) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	res, err := g.Greeter.Hello(ctx, req)
+	if err == nil {
+		grpc.SetTrailer(ctx, metadata.Pairs("served-by", "test"))
+	}
+	return res, err
+}
+
+func startTestServer(t *testing.T) (string, *health.Server) {
+	t.Helper()
+	hs := health.NewServer()
+	addr := grpctest.Listen(t, newGreeter(), grpctest.Register(func(s *grpc.Server) {
+		healthpb.RegisterHealthServer(s, hs)
+	}))
+	return addr, hs
+}
+
+type result struct {
+	code           int
+	stdout, stderr string
+}
+
+func runClient(stdin string, args ...string) result {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return result{code, stdout.String(), stderr.String()}
+}
+
+func TestCommands(t *testing.T) {
+	addr, _ := startTestServer(t)
+	file := filepath.Join(t.TempDir(), "names")
+	if err := os.WriteFile(file, []byte("carol\n\ndave\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"hello arguments", "", []string{"hello", "alice", "bob"}, "Hello, alice!\nHello, bob!\n"},
+		{"hello flags first", "", []string{"hello", "-name", "alice", "bob"}, "Hello, alice!\nHello, bob!\n"},
+		{"hello file", "", []string{"hello", "-file", file}, "Hello, carol!\nHello, dave!\n"},
+		{"hello stdin", "erin\nfrank\n", []string{"hello"}, "Hello, erin!\nHello, frank!\n"},
+		{"server-stream", "", []string{"server-stream", "alice"}, "[0] Hello, alice!\n[1] Hello, alice!\n[2] Hello, alice!\n"},
+		{"client-stream", "alice\nbob\n", []string{"client-stream", "-file", "-"}, "Hello, alice and bob!\n"},
+		{"bidi", "", []string{"bidi", "alice", "bob"}, "Hello, alice!\nHello, bob!\n"},
+		{"health", "", []string{"health"}, "SERVING\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runClient(tt.stdin, append([]string{"-addr", addr}, tt.args...)...)
+			if got.code != 0 || got.stdout != tt.want {
+				t.Errorf("exit %d, stdout:\n%s\nwant:\n%s\nstderr:\n%s", got.code, got.stdout, tt.want, got.stderr)
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	addr, _ := startTestServer(t)
+
+	got := runClient("", "-addr", addr, "-output", "json", "server-stream", "alice")
+	lines := strings.Split(strings.TrimSpace(got.stdout), "\n")
+	if got.code != 0 || len(lines) != 3 {
+		t.Fatalf("exit %d, stdout:\n%s", got.code, got.stdout)
+	}
+	for i, line := range lines {
+		var res struct{ Message string }
+		if err := json.Unmarshal([]byte(line), &res); err != nil || res.Message != fmt.Sprintf("[%d] Hello, alice!", i) {
+			t.Errorf("line %d = %s", i, line)
+		}
+	}
+
+	got = runClient("", "-addr", addr, "-output", "json", "hello", "-name", "")
+	var e struct {
+		Error struct {
+			Code    int
+			Message string
+		}
+	}
+	if err := json.Unmarshal([]byte(got.stderr), &e); err != nil || codes.Code(e.Error.Code) != codes.InvalidArgument {
+		t.Errorf("stderr = %s, want an InvalidArgument status", got.stderr)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	addr, _ := startTestServer(t)
+	got := runClient("", "-addr", addr, "-v", "hello", "alice")
+	if got.stdout != "Hello, alice!\n" {
+		t.Errorf("stdout = %q, want only the reply", got.stdout)
+	}
+	if !strings.Contains(got.stderr, "trailer: served-by: test") || !strings.Contains(got.stderr, "trailer: retry-attempts: 1") {
+		t.Errorf("stderr = %q, want the trailer with the attempts", got.stderr)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	start := time.Now()
+	got := runClient("", "-addr", addr, "-dial-timeout", "200ms", "hello", "alice")
+	if want := exitCodeBase + int(codes.Unavailable); got.code != want {
+		t.Errorf("exit %d, want %d; stderr:\n%s", got.code, want, got.stderr)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("gave up after %s", d)
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	addr, _ := startTestServer(t)
+
+	if err := json.Unmarshal([]byte(defaultServiceConfig), new(interface{})); err != nil {
+		t.Fatalf("default service config: %v", err)
+	}
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(config, []byte(`{"methodConfig": [{"name": [{"service": "myapp.GreetingService"}], "timeout": "0.05s"}]}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	got := runClient("", "-addr", addr, "-service-config", config, "-timeout", "0", "hello", "slow")
+	if want := exitCodeBase + int(codes.DeadlineExceeded); got.code != want {
+		t.Errorf("exit %d, want %d; stderr:\n%s", got.code, want, got.stderr)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("method timeout not applied, took %s", d)
+	}
+
+	if err := os.WriteFile(config, []byte(`{"methodConfig": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := runClient("", "-addr", addr, "-service-config", config, "hello", "alice"); got.code != 1 {
+		t.Errorf("invalid service config: exit %d, want 1", got.code)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	addr, hs := startTestServer(t)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"invalid argument", []string{"hello", "-name", ""}, exitCodeBase + int(codes.InvalidArgument)},
+		{"stops at the first failure", []string{"hello", "-name", "", "alice"}, exitCodeBase + int(codes.InvalidArgument)},
+		{"stream failure", []string{"bidi", "-name", "alice", "-name", ""}, exitCodeBase + int(codes.InvalidArgument)},
+		{"timeout", []string{"-timeout", "50ms", "hello", "slow"}, exitCodeBase + int(codes.DeadlineExceeded)},
+		{"unknown command", []string{"greet"}, 2},
+		{"unknown output", []string{"-output", "yaml", "hello", "alice"}, 2},
+		{"missing file", []string{"hello", "-file", "/nonexistent"}, 1},
+		{"tls key without cert", []string{"-tls-key", "client-key.pem", "hello", "alice"}, 1},
+		{"token without tls", []string{"-token", "secret", "hello", "alice"}, 2},
+		{"insecure token", []string{"-token", "secret", "-insecure-token", "hello", "alice"}, 0},
+		{"no names", []string{"hello"}, 2},
+		{"bad command flag", []string{"hello", "-names", "alice"}, 2},
+		{"no command", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			got := runClient("", append([]string{"-addr", addr}, tt.args...)...)
+			if got.code != tt.want {
+				t.Errorf("exit %d, want %d; stderr:\n%s", got.code, tt.want, got.stderr)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("took %s", d)
+			}
+		})
+	}
+
+	t.Run("not serving", func(t *testing.T) {
+		hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		got := runClient("", "-addr", addr, "health")
+		if got.code != 1 || got.stdout != "NOT_SERVING\n" {
+			t.Errorf("exit %d, stdout %q", got.code, got.stdout)
+		}
+	})
+}
+
+func TestBackendList(t *testing.T) {
+	addr1, _ := startTestServer(t)
+	addr2, hs2 := startTestServer(t)
+	// a draining backend does not keep the client from the other
+	hs2.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	for _, policy := range []string{"", "round_robin", "least_request"} {
+		got := runClient("", "-addr", addr1+","+addr2, "-lb", policy, "hello", "alice", "bob")
+		if got.code != 0 || got.stdout != "Hello, alice!\nHello, bob!\n" {
+			t.Errorf("-lb %q: exit %d, stdout %q, stderr:\n%s", policy, got.code, got.stdout, got.stderr)
+		}
+	}
+
+	if got := runClient("", "-addr", addr1, "-lb", "random", "hello", "alice"); got.code != 2 {
+		t.Errorf("unknown policy: exit %d, want 2", got.code)
+	}
+}
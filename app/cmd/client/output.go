@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// printer writes results to stdout and everything else to stderr, so
+// scripts can pipe stdout without filtering.
+type printer interface {
+	response(res *hellopb.HelloResponse)
+	health(res *healthpb.HealthCheckResponse)
+	bench(r *benchResult)
+	error(err error)
+	// metadata is only printed in verbose mode.
+	metadata(kind string, md metadata.MD)
+}
+
+func newPrinter(format string, stdout, stderr io.Writer, verbose bool) (printer, error) {
+	switch format {
+	case "text":
+		return &textPrinter{stdout, stderr, verbose}, nil
+	case "json":
+		return &jsonPrinter{stdout, stderr, verbose}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, want text or json", format)
+}
+
+// textPrinter prints one reply message per line.
+type textPrinter struct {
+	stdout, stderr io.Writer
+	verbose        bool
+}
+
+func (p *textPrinter) response(res *hellopb.HelloResponse) {
+	fmt.Fprintln(p.stdout, res.GetMessage())
+	if p.verbose && res.GetCreateTime().IsValid() {
+		// the latency includes any clock skew between the two hosts
+		created := res.GetCreateTime().AsTime()
+		fmt.Fprintf(p.stderr, "  created at %s, latency %s\n", created.Local().Format(time.RFC3339Nano), time.Since(created))
+	}
+}
+
+func (p *textPrinter) health(res *healthpb.HealthCheckResponse) {
+	fmt.Fprintln(p.stdout, res.GetStatus())
+}
+
+func (p *textPrinter) bench(r *benchResult) {
+	loop := "closed loop"
+	if r.Rate > 0 {
+		loop = fmt.Sprintf("open loop at %g/s", r.Rate)
+	}
+	fmt.Fprintf(p.stdout, "%s: %d workers, %s\n", r.RPC, r.Concurrency, loop)
+	fmt.Fprintf(p.stdout, "calls:    %d in %s (%.1f/s)\n", r.Calls, r.Elapsed.Round(time.Millisecond), r.Throughput)
+	fmt.Fprintf(p.stdout, "errors:   %d\n", r.Errors)
+	fmt.Fprintf(p.stdout, "latency:  p50 %s, p90 %s, p99 %s, max %s\n", r.P50, r.P90, r.P99, r.Max)
+	fmt.Fprintf(p.stdout, "codes:    %s\n", r.codeList())
+}
+
+func (p *textPrinter) error(err error) {
+	printError(p.stderr, err)
+}
+
+func (p *textPrinter) metadata(kind string, md metadata.MD) {
+	if !p.verbose || len(md) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(p.stderr, "%s: %s: %s\n", kind, k, strings.Join(md[k], ", "))
+	}
+}
+
+// jsonPrinter prints one JSON object per line: replies in the protobuf
+// JSON mapping on stdout, and errors as {"error": google.rpc.Status} on
+// stderr.
+type jsonPrinter struct {
+	stdout, stderr io.Writer
+	verbose        bool
+}
+
+func (p *jsonPrinter) write(w io.Writer, m proto.Message) {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		fmt.Fprintln(p.stderr, err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", b)
+}
+
+func (p *jsonPrinter) response(res *hellopb.HelloResponse) {
+	p.write(p.stdout, res)
+}
+
+func (p *jsonPrinter) health(res *healthpb.HealthCheckResponse) {
+	p.write(p.stdout, res)
+}
+
+func (p *jsonPrinter) bench(r *benchResult) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		fmt.Fprintln(p.stderr, err)
+		return
+	}
+	fmt.Fprintf(p.stdout, "%s\n", b)
+}
+
+func (p *jsonPrinter) error(err error) {
+	b, merr := protojson.Marshal(status.Convert(err).Proto())
+	if merr != nil {
+		fmt.Fprintln(p.stderr, err)
+		return
+	}
+	fmt.Fprintf(p.stderr, "{\"error\":%s}\n", b)
+}
+
+func (p *jsonPrinter) metadata(kind string, md metadata.MD) {
+	if !p.verbose || len(md) == 0 {
+		return
+	}
+	b, err := json.Marshal(map[string]metadata.MD{kind: md})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(p.stderr, "%s\n", b)
+}
+
+// printError shows the code, message and each detail of a gRPC status.
+func printError(w io.Writer, err error) {
+	stat, ok := status.FromError(err)
+	if !ok {
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintf(w, "code: %s\n", stat.Code())
+	fmt.Fprintf(w, "message: %s\n", stat.Message())
+	for _, d := range stat.Details() {
+		switch d := d.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				fmt.Fprintf(w, "  bad request: %s %s\n", v.GetField(), v.GetDescription())
+			}
+		case *errdetails.QuotaFailure:
+			for _, v := range d.GetViolations() {
+				fmt.Fprintf(w, "  quota failure: %s %s\n", v.GetSubject(), v.GetDescription())
+			}
+		case *errdetails.PreconditionFailure:
+			for _, v := range d.GetViolations() {
+				fmt.Fprintf(w, "  precondition failure: [%s] %s %s\n", v.GetType(), v.GetSubject(), v.GetDescription())
+			}
+		case *errdetails.ErrorInfo:
+			fmt.Fprintf(w, "  error info: %s (domain %s) %v\n", d.GetReason(), d.GetDomain(), d.GetMetadata())
+		case *errdetails.RetryInfo:
+			fmt.Fprintf(w, "  retry after: %s\n", d.GetRetryDelay().AsDuration())
+		case *errdetails.DebugInfo:
+			fmt.Fprintf(w, "  debug info: %s\n", d.GetDetail())
+			for _, e := range d.GetStackEntries() {
+				fmt.Fprintf(w, "    %s\n", e)
+			}
+		case *errdetails.ResourceInfo:
+			fmt.Fprintf(w, "  resource: %s %s (owner %s) %s\n", d.GetResourceType(), d.GetResourceName(), d.GetOwner(), d.GetDescription())
+		case *errdetails.RequestInfo:
+			fmt.Fprintf(w, "  request: %s %s\n", d.GetRequestId(), d.GetServingData())
+		case *errdetails.Help:
+			for _, l := range d.GetLinks() {
+				fmt.Fprintf(w, "  help: %s %s\n", l.GetDescription(), l.GetUrl())
+			}
+		case *errdetails.LocalizedMessage:
+			fmt.Fprintf(w, "  message (%s): %s\n", d.GetLocale(), d.GetMessage())
+		case error:
+			// a detail type this client has not been built with
+			fmt.Fprintf(w, "  undecodable detail: %v\n", d)
+		default:
+			fmt.Fprintf(w, "  detail: %v\n", d)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+// defaultServiceConfig bounds each attempt of a call with a method timeout
+// and lets gRPC retry HelloServerStream while the server is unavailable.
+// Hello is retried by retry.UnaryClientInterceptor instead, which reports
+// the attempts, so it has no retryPolicy here. HelloBiStreams is left
+// unbounded, being as long as the conversation.
+//
+// Calls are spread over all the backends the target resolves to, leaving
+// out those whose health service reports them not serving, as a server
+// does while it shuts down. gRPC-Go ignores hedgingPolicy, so there is
+// none.
+const defaultServiceConfig = `{
+  "loadBalancingConfig": [{"round_robin": {}}],
+  "healthCheckConfig": {"serviceName": ""},
+  "methodConfig": [
+    {
+      "name": [{"service": "myapp.GreetingService", "method": "Hello"}],
+      "timeout": "2s"
+    },
+    {
+      "name": [{"service": "myapp.GreetingService", "method": "HelloServerStream"}],
+      "timeout": "30s",
+      "retryPolicy": {
+        "maxAttempts": 3,
+        "initialBackoff": "0.1s",
+        "maxBackoff": "1s",
+        "backoffMultiplier": 2,
+        "retryableStatusCodes": ["UNAVAILABLE"]
+      }
+    },
+    {
+      "name": [{"service": "myapp.GreetingService", "method": "HelloClientStream"}],
+      "timeout": "30s"
+    },
+    {
+      "name": [{"service": "grpc.health.v1.Health"}],
+      "timeout": "5s"
+    }
+  ]
+}`
+
+// idempotentMethods may be sent again when an attempt fails; greeting has
+// no side effects.
+var idempotentMethods = []string{
+	"/myapp.GreetingService/Hello",
+}
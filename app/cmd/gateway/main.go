@@ -0,0 +1,81 @@
+// Command gateway serves GreetingService as HTTP/JSON, server-sent events
+// and WebSocket in front of a gRPC backend. See package gateway for the
+// routes.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"mygrpc/pkg/gateway"
+	hellopb "mygrpc/pkg/grpc"
+	"mygrpc/pkg/tlsutil"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "HTTP listen address")
+	backend := flag.String("backend", "localhost:50051", "address of the gRPC server")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for requests to finish on SIGINT/SIGTERM")
+
+	var tlsConfig tlsutil.Config
+	useTLS := flag.Bool("tls", false, "connect to the backend over TLS; implied by the other -tls flags")
+	flag.StringVar(&tlsConfig.CAFile, "tls-ca", "", "CA that signs the backend certificate; the system roots when empty")
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "client certificate for backends that require one")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "client private key")
+	flag.StringVar(&tlsConfig.ServerName, "tls-server-name", "", "name to verify the backend certificate against")
+	flag.Parse()
+
+	var creds credentials.TransportCredentials = insecure.NewCredentials()
+	if *useTLS || tlsConfig.CAFile != "" || tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" || tlsConfig.ServerName != "" {
+		var err error
+		if creds, err = tlsutil.ClientCredentials(tlsConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The Authorization header of each HTTP request is forwarded, so the
+	// gateway itself holds no token.
+	conn, err := grpc.Dial(*backend, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           gateway.New(hellopb.NewGreetingServiceClient(conn)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("gateway listening on %s, backend %s", *addr, *backend)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	log.Println("stopping gateway...")
+
+	// Shutdown does not wait for hijacked WebSocket connections; closing
+	// conn afterwards ends their streams.
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("forcing close: %v", err)
+		srv.Close()
+	}
+}
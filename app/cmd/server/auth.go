@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"time"
+
+	"mygrpc/pkg/auth"
+)
+
+// publicMethods can be called without a token.
+var publicMethods = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+type AuthConfig struct {
+	APIKeysFile   string        `envconfig:"API_KEYS_FILE"`
+	JWTSecretFile string        `envconfig:"JWT_SECRET_FILE"`
+	JWTIssuer     string        `envconfig:"JWT_ISSUER"`
+	JWTAudience   string        `envconfig:"JWT_AUDIENCE"`
+	JWTLeeway     time.Duration `envconfig:"JWT_LEEWAY" default:"1m"`
+	// JWTAllowNoExpiry accepts JWTs without an exp claim, for issuers
+	// that cannot set one.
+	JWTAllowNoExpiry bool `envconfig:"JWT_ALLOW_NO_EXPIRY"`
+}
+
+// newAuthenticator returns nil when neither API keys nor a JWT secret are
+// configured, which leaves the server open.
+func newAuthenticator(cfg AuthConfig) (auth.Authenticator, error) {
+	var chain auth.Chain
+	if cfg.APIKeysFile != "" {
+		keys, err := auth.LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if cfg.JWTSecretFile != "" {
+		secret, err := os.ReadFile(cfg.JWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, errors.New(cfg.JWTSecretFile + ": empty JWT secret")
+		}
+		chain = append(chain, &auth.JWTVerifier{
+			Secret:        secret,
+			Issuer:        cfg.JWTIssuer,
+			Audience:      cfg.JWTAudience,
+			Leeway:        cfg.JWTLeeway,
+			AllowNoExpiry: cfg.JWTAllowNoExpiry,
+		})
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/keepalive"
+
+	"mygrpc/pkg/auth"
+	"mygrpc/pkg/logging"
+	"mygrpc/pkg/ratelimit"
+	"mygrpc/pkg/tlsutil"
+	"mygrpc/pkg/tracing"
+)
+
+// Config is read from GRPC_* environment variables first; command line
+// flags override them.
+type Config struct {
+	Addr                 string          `envconfig:"ADDR" default:":50051"`
+	MaxRecvMsgSize       int             `envconfig:"MAX_RECV_MSG_SIZE" default:"4194304"`
+	MaxSendMsgSize       int             `envconfig:"MAX_SEND_MSG_SIZE" default:"2147483647"`
+	MaxConcurrentStreams uint32          `envconfig:"MAX_CONCURRENT_STREAMS"`
+	Keepalive            KeepaliveConfig `envconfig:"KEEPALIVE"`
+	// Interceptors names the optional interceptors to install, in order.
+	// The error interceptor always runs, and the tracing, metrics and auth
+	// ones whenever those are configured.
+	Interceptors    []string      `envconfig:"INTERCEPTORS" default:"logging"`
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"10s"`
+	// DrainDelay keeps serving after health turns NOT_SERVING, giving
+	// load balancers time to stop sending new calls.
+	DrainDelay time.Duration `envconfig:"DRAIN_DELAY"`
+	// MetricsAddr serves Prometheus metrics at /metrics, without
+	// authentication; empty, the default, disables them.
+	MetricsAddr string `envconfig:"METRICS_ADDR"`
+	// DebugErrors sends the text of internal errors to clients as
+	// DebugInfo. It shows server internals, so keep it off in production.
+	DebugErrors bool           `envconfig:"DEBUG_ERRORS"`
+	Log         logging.Config `envconfig:"LOG"`
+	Tracing     tracing.Config `envconfig:"TRACING"`
+	TLS         tlsutil.Config `envconfig:"TLS"`
+	Auth        AuthConfig     `envconfig:"AUTH"`
+	// RateLimit applies to every method but health and reflection.
+	RateLimit ratelimit.Config `envconfig:"RATE_LIMIT"`
+}
+
+// KeepaliveConfig combines the server parameters and the enforcement
+// policy for client pings. Zero durations keep the gRPC defaults.
+type KeepaliveConfig struct {
+	Time                time.Duration `envconfig:"TIME"`
+	Timeout             time.Duration `envconfig:"TIMEOUT"`
+	MaxConnectionIdle   time.Duration `envconfig:"MAX_CONNECTION_IDLE"`
+	MaxConnectionAge    time.Duration `envconfig:"MAX_CONNECTION_AGE"`
+	MinTime             time.Duration `envconfig:"MIN_TIME" default:"5m"`
+	PermitWithoutStream bool          `envconfig:"PERMIT_WITHOUT_STREAM"`
+}
+
+type interceptorPair struct {
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+}
+
+// interceptors are the ones Config.Interceptors can enable.
+var interceptors = map[string]func(c Config, in instruments) interceptorPair{
+	"logging": func(c Config, in instruments) interceptorPair {
+		opts := logging.Options{Payloads: c.Log.Payloads}
+		return interceptorPair{
+			logging.UnaryServerInterceptor(in.logger, opts),
+			logging.StreamServerInterceptor(in.logger, opts),
+		}
+	},
+}
+
+func interceptorNames() string {
+	names := make([]string, 0, len(interceptors))
+	for name := range interceptors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// LoadConfig reads the environment and then parses args, which do not
+// include the program name.
+func LoadConfig(args []string) (Config, error) {
+	var c Config
+	if err := envconfig.Process("GRPC", &c); err != nil {
+		return Config{}, err
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&c.Addr, "addr", c.Addr, "listen address")
+	fs.IntVar(&c.MaxRecvMsgSize, "max-recv-msg-size", c.MaxRecvMsgSize, "largest message the server accepts, in bytes")
+	fs.IntVar(&c.MaxSendMsgSize, "max-send-msg-size", c.MaxSendMsgSize, "largest message the server sends, in bytes")
+	maxStreams := uint(c.MaxConcurrentStreams)
+	fs.UintVar(&maxStreams, "max-concurrent-streams", maxStreams, "streams allowed per connection; 0 is unlimited")
+	fs.DurationVar(&c.Keepalive.Time, "keepalive-time", c.Keepalive.Time, "ping an idle client after this long")
+	fs.DurationVar(&c.Keepalive.Timeout, "keepalive-timeout", c.Keepalive.Timeout, "close the connection when a ping is not answered within this")
+	fs.DurationVar(&c.Keepalive.MaxConnectionIdle, "keepalive-max-idle", c.Keepalive.MaxConnectionIdle, "close connections idle for this long")
+	fs.DurationVar(&c.Keepalive.MaxConnectionAge, "keepalive-max-age", c.Keepalive.MaxConnectionAge, "close connections older than this")
+	fs.DurationVar(&c.Keepalive.MinTime, "keepalive-min-time", c.Keepalive.MinTime, "shortest ping interval allowed to clients")
+	fs.BoolVar(&c.Keepalive.PermitWithoutStream, "keepalive-permit-without-stream", c.Keepalive.PermitWithoutStream, "allow client pings without active streams")
+	fs.Func("interceptors", fmt.Sprintf("comma separated interceptors to install, from %s (default %q)", interceptorNames(), strings.Join(c.Interceptors, ",")), func(s string) error {
+		c.Interceptors = nil
+		for _, name := range strings.Split(s, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Interceptors = append(c.Interceptors, name)
+			}
+		}
+		return nil
+	})
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long to wait for calls to finish before closing them")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "listen address of the unauthenticated HTTP /metrics endpoint, e.g. localhost:9090; metrics are off when empty")
+	fs.BoolVar(&c.DebugErrors, "debug-errors", c.DebugErrors, "send the text of internal errors to clients as DebugInfo")
+	fs.DurationVar(&c.DrainDelay, "drain-delay", c.DrainDelay, "how long to keep serving after reporting NOT_SERVING on shutdown")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "lowest level logged: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "json or console")
+	fs.BoolVar(&c.Log.Payloads, "log-payloads", c.Log.Payloads, "log request and response messages, with sensitive fields redacted")
+
+	fs.StringVar(&c.Tracing.Exporter, "trace-exporter", c.Tracing.Exporter, "where spans go: none, stdout or otlp")
+	fs.StringVar(&c.Tracing.Endpoint, "trace-endpoint", c.Tracing.Endpoint, "host:port of the OTLP/HTTP collector")
+	fs.BoolVar(&c.Tracing.Insecure, "trace-insecure", c.Tracing.Insecure, "send spans to the collector without TLS")
+	fs.Float64Var(&c.Tracing.SampleRatio, "trace-sample-ratio", c.Tracing.SampleRatio, "share of new traces recorded")
+
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "server certificate; serves plaintext when empty")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "server private key")
+	fs.StringVar(&c.TLS.CAFile, "tls-ca", c.TLS.CAFile, "CA that signs client certificates")
+	fs.BoolVar(&c.TLS.ClientAuth, "tls-client-auth", c.TLS.ClientAuth, "require a client certificate signed by -tls-ca")
+	fs.DurationVar(&c.TLS.ReloadInterval, "tls-reload", c.TLS.ReloadInterval, "how often the certificate files are checked for changes (default 10s)")
+
+	fs.StringVar(&c.Auth.APIKeysFile, "auth-keys", c.Auth.APIKeysFile, "file of \"subject key\" lines accepted as bearer tokens")
+	fs.StringVar(&c.Auth.JWTSecretFile, "auth-jwt-secret", c.Auth.JWTSecretFile, "file holding the HMAC secret of accepted JWTs")
+	fs.StringVar(&c.Auth.JWTIssuer, "auth-jwt-issuer", c.Auth.JWTIssuer, "required iss claim of JWTs")
+	fs.StringVar(&c.Auth.JWTAudience, "auth-jwt-audience", c.Auth.JWTAudience, "required aud claim of JWTs")
+	fs.DurationVar(&c.Auth.JWTLeeway, "auth-jwt-leeway", c.Auth.JWTLeeway, "allowed clock skew for exp and nbf")
+	fs.BoolVar(&c.Auth.JWTAllowNoExpiry, "auth-jwt-allow-no-exp", c.Auth.JWTAllowNoExpiry, "accept JWTs without an exp claim")
+
+	fs.Float64Var(&c.RateLimit.Rate, "rate-limit", c.RateLimit.Rate, "calls per second each caller may make to each method; 0 is unlimited")
+	fs.IntVar(&c.RateLimit.Burst, "rate-limit-burst", c.RateLimit.Burst, "calls a caller may make at once above the rate (default the rate, at least 1)")
+	fs.Var(&c.RateLimit.Methods, "rate-limit-methods", "per method limits overriding -rate-limit, as Hello:5/10,HelloBiStreams:1")
+	fs.StringVar(&c.RateLimit.Key, "rate-limit-key", c.RateLimit.Key, "what a caller is: peer, caller (authenticated subject) or a metadata key")
+	fs.IntVar(&c.RateLimit.MaxStreams, "max-streams-per-client", c.RateLimit.MaxStreams, "streams each caller may have open at once; 0 is unlimited")
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	c.MaxConcurrentStreams = uint32(maxStreams)
+
+	for _, name := range c.Interceptors {
+		if _, ok := interceptors[name]; !ok {
+			return Config{}, fmt.Errorf("unknown interceptor %q, want one of %s", name, interceptorNames())
+		}
+	}
+	return c, nil
+}
+
+// instruments observe the calls; nil metrics and tracer are skipped.
+type instruments struct {
+	logger  zerolog.Logger
+	metrics *serverMetrics
+	tracer  trace.TracerProvider
+}
+
+// ServerOptions turns c into the options for grpc.NewServer.
+func (c Config) ServerOptions(in instruments) ([]grpc.ServerOption, error) {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(c.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(c.MaxSendMsgSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:              c.Keepalive.Time,
+			Timeout:           c.Keepalive.Timeout,
+			MaxConnectionIdle: c.Keepalive.MaxConnectionIdle,
+			MaxConnectionAge:  c.Keepalive.MaxConnectionAge,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             c.Keepalive.MinTime,
+			PermitWithoutStream: c.Keepalive.PermitWithoutStream,
+		}),
+	}
+	if c.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(c.MaxConcurrentStreams))
+	}
+
+	if c.TLS.CertFile != "" {
+		creds, err := tlsutil.ServerCredentials(c.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	var (
+		unary  []grpc.UnaryServerInterceptor
+		stream []grpc.StreamServerInterceptor
+	)
+	authenticator, err := newAuthenticator(c.Auth)
+	if err != nil {
+		return nil, err
+	}
+	// outermost, so rejected calls are traced and counted too
+	if in.tracer != nil {
+		unary = append(unary, tracing.UnaryServerInterceptor(in.tracer))
+		stream = append(stream, tracing.StreamServerInterceptor(in.tracer))
+	}
+	if in.metrics != nil {
+		unary = append(unary, in.metrics.unaryInterceptor)
+		stream = append(stream, in.metrics.streamInterceptor)
+	}
+	// before auth and the rate limiter, so the calls they reject are logged
+	// and get a request ID too
+	for _, name := range c.Interceptors {
+		pair := interceptors[name](c, in)
+		unary = append(unary, pair.unary)
+		stream = append(stream, pair.stream)
+	}
+	if authenticator != nil {
+		// reject unauthenticated calls before the handler sees them
+		unary = append(unary, auth.UnaryServerInterceptor(authenticator, publicMethods...))
+		stream = append(stream, auth.StreamServerInterceptor(authenticator, publicMethods...))
+	}
+	if c.RateLimit.Enabled() {
+		// after auth, to tell callers apart by who they are
+		limiter := ratelimit.New(c.RateLimit, publicMethods...)
+		unary = append(unary, limiter.UnaryServerInterceptor())
+		stream = append(stream, limiter.StreamServerInterceptor())
+	}
+	// innermost, so it logs with the call's logger and request ID
+	errs := errorInterceptor{logger: in.logger, debug: c.DebugErrors}
+	unary = append(unary, errs.unary)
+	stream = append(stream, errs.stream)
+
+	return append(opts,
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	), nil
+}
+
+// stopServer reports every service as NOT_SERVING, waits DrainDelay and
+// then shuts s down within ShutdownTimeout.
+func (c Config) stopServer(s *grpc.Server, hs *health.Server) bool {
+	hs.Shutdown()
+	time.Sleep(c.DrainDelay)
+	return shutdown(s, c.ShutdownTimeout)
+}
+
+// shutdown lets running calls finish for up to timeout, then closes the
+// remaining ones. It reports whether the stop was graceful.
+func shutdown(s *grpc.Server, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		s.Stop()
+		<-done
+		return false
+	}
+}
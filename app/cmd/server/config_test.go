@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+	"mygrpc/pkg/logging"
+	"mygrpc/pkg/ratelimit"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("GRPC_ADDR", ":6000")
+	t.Setenv("GRPC_KEEPALIVE_TIME", "30s")
+	t.Setenv("GRPC_TLS_CERT_FILE", "server.pem")
+	t.Setenv("GRPC_INTERCEPTORS", "")
+	t.Setenv("GRPC_LOG_PAYLOADS", "true")
+	t.Setenv("GRPC_RATE_LIMIT_RATE", "5")
+	t.Setenv("GRPC_RATE_LIMIT_METHODS", "HelloBiStreams:1/2")
+
+	c, err := LoadConfig([]string{"-addr", "127.0.0.1:7000", "-max-concurrent-streams", "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != "127.0.0.1:7000" {
+		t.Errorf("Addr = %q, flag should override the environment", c.Addr)
+	}
+	if c.Keepalive.Time != 30*time.Second {
+		t.Errorf("Keepalive.Time = %v, want 30s", c.Keepalive.Time)
+	}
+	if c.TLS.CertFile != "server.pem" {
+		t.Errorf("TLS.CertFile = %q, want server.pem", c.TLS.CertFile)
+	}
+	if c.MaxConcurrentStreams != 8 {
+		t.Errorf("MaxConcurrentStreams = %d, want 8", c.MaxConcurrentStreams)
+	}
+	if c.ShutdownTimeout != 10*time.Second {
+		t.Errorf("ShutdownTimeout = %v, want the 10s default", c.ShutdownTimeout)
+	}
+	if len(c.Interceptors) != 0 {
+		t.Errorf("Interceptors = %q, want none", c.Interceptors)
+	}
+	if c.MetricsAddr != "" {
+		t.Errorf("MetricsAddr = %q, want metrics off by default", c.MetricsAddr)
+	}
+	if !c.Log.Payloads {
+		t.Error("Log.Payloads not read from GRPC_LOG_PAYLOADS")
+	}
+	if c.RateLimit.Rate != 5 || c.RateLimit.Key != "peer" {
+		t.Errorf("RateLimit = %+v, want rate 5 keyed on peer", c.RateLimit)
+	}
+	if got := c.RateLimit.Methods["HelloBiStreams"]; got != (ratelimit.Limit{Rate: 1, Burst: 2}) {
+		t.Errorf("RateLimit.Methods = %v, want HelloBiStreams:1/2", c.RateLimit.Methods)
+	}
+
+	c, err = LoadConfig([]string{"-interceptors", "logging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Interceptors, []string{"logging"}) {
+		t.Errorf("Interceptors = %q, want [logging]", c.Interceptors)
+	}
+
+	c, err = LoadConfig([]string{"-rate-limit-methods", "Hello:2", "-max-streams-per-client", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.RateLimit.Methods.String(); got != "Hello:2" || c.RateLimit.MaxStreams != 3 {
+		t.Errorf("RateLimit = %+v, want the flags", c.RateLimit)
+	}
+	if _, err := LoadConfig([]string{"-rate-limit-methods", "Hello"}); err == nil {
+		t.Error("no error for a method limit without a rate")
+	}
+
+	if _, err := LoadConfig([]string{"-interceptors", "logging,nope"}); err == nil {
+		t.Error("no error for unknown interceptor")
+	}
+}
+
+func TestServerOptionsLogRejectedCalls(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keys, []byte("alice secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Interceptors, c.Auth.APIKeysFile = []string{"logging"}, keys
+	var buf bytes.Buffer
+	opts, err := c.ServerOptions(instruments{logger: zerolog.New(&buf)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startTestServer(t, NewMyServer(), opts...)
+
+	var header metadata.MD
+	_, err = client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}, grpc.Header(&header))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated", err)
+	}
+	if ids := header.Get(logging.RequestIDHeader); len(ids) != 1 || ids[0] == "" {
+		t.Errorf("%s = %q on a rejected call", logging.RequestIDHeader, ids)
+	}
+	if line := buf.String(); !strings.Contains(line, `"code":"Unauthenticated"`) {
+		t.Errorf("rejected call not logged: %q", line)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	start := func(t *testing.T) (*grpc.Server, hellopb.GreetingServiceClient) {
+		var s *grpc.Server
+		client := grpctest.Dial(t, NewMyServer(), grpctest.Register(func(srv *grpc.Server) { s = srv }))
+		return s, client
+	}
+
+	t.Run("idle", func(t *testing.T) {
+		s, client := start(t)
+		if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+			t.Fatal(err)
+		}
+		if !shutdown(s, time.Second) {
+			t.Error("idle server was not stopped gracefully")
+		}
+	})
+
+	t.Run("open stream", func(t *testing.T) {
+		s, client := start(t)
+		stream, err := client.HelloBiStreams(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// make sure the stream reached the server before stopping it
+		if err := stream.Send(&hellopb.HelloRequest{Name: "gopher"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+
+		begin := time.Now()
+		if shutdown(s, 50*time.Millisecond) {
+			t.Error("shutdown waited for a stream that never ends")
+		}
+		if d := time.Since(begin); d > time.Second {
+			t.Errorf("shutdown took %v", d)
+		}
+		if _, err := stream.Recv(); err == nil {
+			t.Error("stream still open after shutdown")
+		}
+	})
+}
+
+func TestStopServerHealth(t *testing.T) {
+	var s *grpc.Server
+	hs := health.NewServer()
+	service := hellopb.GreetingService_ServiceDesc.ServiceName
+	hs.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	conn := grpctest.Conn(t, grpctest.Greeter{}, grpctest.Register(func(srv *grpc.Server) {
+		s = srv
+		healthpb.RegisterHealthServer(srv, hs)
+	}))
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("status = %v before shutdown", res.GetStatus())
+	}
+
+	stopped := make(chan bool, 1)
+	go func() {
+		cfg := Config{DrainDelay: 100 * time.Millisecond, ShutdownTimeout: time.Second}
+		stopped <- cfg.stopServer(s, hs)
+	}()
+
+	// NOT_SERVING must be visible while the server still accepts calls
+	res, err = watch.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status = %v after shutdown began", res.GetStatus())
+	}
+	res2, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check during drain: %v", err)
+	}
+	if res2.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("server status = %v during drain", res2.GetStatus())
+	}
+
+	cancel()
+	if !<-stopped {
+		t.Error("server was not stopped gracefully")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"log"
@@ -10,77 +11,65 @@ import (
 	"errors"
 	"io"
 	"os/signal"
+	"syscall"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
-	// "google.golang.org/grpc/codes"
-	// "google.golang.org/grpc/status"
-	// "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"mygrpc/pkg/auth"
+	"mygrpc/pkg/logging"
 	hellopb "mygrpc/pkg/grpc"
+	"mygrpc/pkg/tracing"
 )
 
 type myServer struct {
 	hellopb.UnimplementedGreetingServiceServer
+	now            func() time.Time
+	streamInterval time.Duration
 }
 
-func NewMyServer() *myServer {
-	return &myServer{}
-}
-
-func myUnaryServerInterceptor1(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	log.Println("[pre] my unary server interceptor 1: ", info.FullMethod)
-	res, err := handler(ctx, req)
-	log.Println("[post] my unary server interceptor 1: ", res)
-	return res, err
-}
-
-func myUnaryServerInterceptor2(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	log.Println("[pre] my unary server interceptor 2: ", info.FullMethod, req)
-	res, err := handler(ctx, req)
-	log.Println("[post] my unary server interceptor 2: ", res)
-	return res, err
-}
-
-func myStreamServerInterceptor1(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	log.Println("[pre stream] my stream server interceptor 1: ", info.FullMethod)
+type ServerOption func(s *myServer)
 
-	err := handler(srv, &myServerStreamWrapper1{ss})
-
-	log.Println("[post stream] my stream server interceptor 1: ")
-	return err
-}
-
-func myStreamServerInterceptor2(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	log.Println("[pre stream] my stream server interceptor 2: ", info.FullMethod)
-
-	err := handler(srv, &myServerStreamWrapper1{ss})
-
-	log.Println("[post stream] my stream server interceptor 2: ")
-	return err
+// WithClock replaces time.Now as the source of HelloResponse.create_time.
+func WithClock(now func() time.Time) ServerOption {
+	return func(s *myServer) { s.now = now }
 }
 
-type myServerStreamWrapper1 struct {
-	grpc.ServerStream
+// WithStreamInterval sets the pause between two HelloServerStream responses.
+func WithStreamInterval(d time.Duration) ServerOption {
+	return func(s *myServer) { s.streamInterval = d }
 }
 
-func (s *myServerStreamWrapper1) RecvMsg(m interface{}) error {
-	err := s.ServerStream.RecvMsg(m)
-	if !errors.Is(err, io.EOF) {
-		log.Println("[pre message] my stream server interceptor 1: ", m)
+func NewMyServer(opts ...ServerOption) *myServer {
+	s := &myServer{now: time.Now, streamInterval: time.Second}
+	for _, opt := range opts {
+		opt(s)
 	}
-	return err
+	return s
 }
 
-func (s *myServerStreamWrapper1) SendMsg(m interface{}) error {
-	log.Println("[post message] my stream server interceptor 1: ", m)
-	return s.ServerStream.SendMsg(m)
+func (s *myServer) newResponse(message string) *hellopb.HelloResponse {
+	return &hellopb.HelloResponse{
+		Message:    message,
+		CreateTime: timestamppb.New(s.now()),
+	}
 }
 
 func (s *myServer) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
-	// err := status.Error(codes.Unknown, "unknown error occured")
-
+	logger := zerolog.Ctx(ctx)
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
-		log.Println(md)
+		logger.Debug().Interface("metadata", logging.Metadata(md)).Msg("incoming metadata")
+	}
+	if id, ok := auth.FromContext(ctx); ok {
+		logger.Debug().Str("caller", id.Subject).Str("auth", id.Method).Msg("authenticated")
+	}
+
+	if err := validateName("name", req.GetName()); err != nil {
+		return nil, err
 	}
 
 	headerMD := metadata.New(map[string]string{"type": "unary", "from": "server", "in": "header"})
@@ -93,28 +82,20 @@ func (s *myServer) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hello
 		return nil, err
 	}
 
-	return &hellopb.HelloResponse{
-		Message: fmt.Sprintf("Hello, %s!", req.GetName()),
-	}, nil
-
-	// stat := status.New(codes.Unknown, "unknown error occurred")
-	// // stat, _ = stat.WithDetails()
-	// stat, _ = stat.WithDetails(&errdetails.DebugInfo{
-	// 	Detail: "detail reason of err",
-	// })
-	// err := stat.Err()
-	// return nil, err
+	return s.newResponse(fmt.Sprintf("Hello, %s!", req.GetName())), nil
 }
 
 func (s *myServer) HelloServerStream(req *hellopb.HelloRequest, stream hellopb.GreetingService_HelloServerStreamServer) error {
+	if err := validateName("name", req.GetName()); err != nil {
+		return err
+	}
+
 	resCount := 5
 	for i := 0; i < resCount; i++ {
-		if err := stream.Send(&hellopb.HelloResponse{
-			Message: fmt.Sprintf("[%d] Hello, %s!", i, req.GetName()),
-		}); err != nil {
+		if err := stream.Send(s.newResponse(fmt.Sprintf("[%d] Hello, %s!", i, req.GetName()))); err != nil {
 			return err
 		}
-		time.Sleep(time.Second * 1)
+		time.Sleep(s.streamInterval)
 	}
 	return nil
 }
@@ -125,20 +106,24 @@ func (s *myServer) HelloClientStream(stream hellopb.GreetingService_HelloClientS
 		req, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
 			message := fmt.Sprintf("Hello, %v!", nameList)
-			return stream.SendAndClose(&hellopb.HelloResponse{
-				Message: message,
-			})
+			return stream.SendAndClose(s.newResponse(message))
 		}
 		if err != nil {
 			return err
 		}
+		if len(nameList) == maxStreamNames {
+			return quotaError("requests", fmt.Sprintf("must be at most %d per stream", maxStreamNames))
+		}
+		if err := validateName(fmt.Sprintf("requests[%d].name", len(nameList)), req.GetName()); err != nil {
+			return err
+		}
 		nameList = append(nameList, req.GetName())
 	}
 }
 
 func (s *myServer) HelloBiStreams(stream hellopb.GreetingService_HelloBiStreamsServer) error {
 	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
-		log.Println(md)
+		zerolog.Ctx(stream.Context()).Debug().Interface("metadata", logging.Metadata(md)).Msg("incoming metadata")
 	}
 
 	headerMD := metadata.New(map[string]string{"type": "stream", "from": "server", "in": "header"})
@@ -149,7 +134,7 @@ func (s *myServer) HelloBiStreams(stream hellopb.GreetingService_HelloBiStreamsS
 	trailerMD := metadata.New(map[string]string{"type": "stream", "from": "server", "in": "trailer"})
 	stream.SetTrailer(trailerMD)
 
-	for {
+	for i := 0; ; i++ {
 		req, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
 			return nil
@@ -157,44 +142,82 @@ func (s *myServer) HelloBiStreams(stream hellopb.GreetingService_HelloBiStreamsS
 		if err != nil {
 			return err
 		}
+		if err := validateName(fmt.Sprintf("requests[%d].name", i), req.GetName()); err != nil {
+			return err
+		}
 		message := fmt.Sprintf("Hello, %v!", req.GetName())
-		if err := stream.Send(&hellopb.HelloResponse{
-			Message: message,
-		}); err != nil {
+		if err := stream.Send(s.newResponse(message)); err != nil {
 			return err
 		}
 	}
 }
 
 func main() {
-	port := 50051
-	listner, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listner, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	in := instruments{logger: logger}
+	if cfg.MetricsAddr != "" {
+		reg := prometheus.NewRegistry()
+		in.metrics = newServerMetrics(reg)
+		metricsServer := serveMetrics(cfg.MetricsAddr, reg)
+		defer metricsServer.Close()
+	}
+	tp, shutdownTracing, err := tracing.NewProvider(context.Background(), "greeting-server", cfg.Tracing)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+	if cfg.Tracing.Enabled() {
+		in.tracer = tp
 	}
 
-	// s := grpc.NewServer(
-	// 	grpc.UnaryInterceptor(myUnaryServerInterceptor1),
-	// )
-	s := grpc.NewServer(
-		grpc.ChainStreamInterceptor(
-			myStreamServerInterceptor1,
-			myStreamServerInterceptor2,
-		),
-	)
+	opts, err := cfg.ServerOptions(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := grpc.NewServer(opts...)
 
 	hellopb.RegisterGreetingServiceServer(s, NewMyServer())
 
+	// the empty service name stands for the whole server
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(hellopb.GreetingService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+
 	reflection.Register(s)
 
+	serveErr := make(chan error, 1)
 	go func() {
-		log.Printf("Start gRPC server port: %v", port)
-		s.Serve(listner)
+		log.Printf("Start gRPC server on %v", listner.Addr())
+		serveErr <- s.Serve(listner)
 	}()
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case sig := <-quit:
+		log.Printf("Received %v", sig)
+	}
+
 	log.Println("Stopping gRPC server...")
-	s.GracefulStop()
+	if !cfg.stopServer(s, healthServer) {
+		log.Printf("Calls still running after %v were closed", cfg.ShutdownTimeout)
+	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+	"mygrpc/pkg/logging"
+)
+
+// startTestServer serves srv over an in-memory listener and returns a
+// client connected to it.
+func startTestServer(t *testing.T, srv *myServer, opts ...grpc.ServerOption) hellopb.GreetingServiceClient {
+	t.Helper()
+	return grpctest.Dial(t, srv, grpctest.ServerOptions(opts...))
+}
+
+func TestCreateTime(t *testing.T) {
+	stamp := time.Date(2023, time.January, 4, 16, 33, 17, 0, time.UTC)
+	client := startTestServer(t, NewMyServer(
+		WithClock(func() time.Time { return stamp }),
+		WithStreamInterval(0),
+	))
+	ctx := context.Background()
+
+	check := func(rpc string, res *hellopb.HelloResponse) {
+		t.Helper()
+		if got := res.GetCreateTime().AsTime(); !got.Equal(stamp) {
+			t.Errorf("%s: create_time = %v, want %v", rpc, got, stamp)
+		}
+	}
+
+	res, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Hello", res)
+
+	ss, err := client.HelloServerStream(ctx, &hellopb.HelloRequest{Name: "gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		res, err := ss.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("HelloServerStream", res)
+	}
+
+	cs, err := client.HelloClientStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.Send(&hellopb.HelloRequest{Name: "gopher"})
+	res, err = cs.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("HelloClientStream", res)
+
+	bs, err := client.HelloBiStreams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.Send(&hellopb.HelloRequest{Name: "gopher"})
+	res, err = bs.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("HelloBiStreams", res)
+	bs.CloseSend()
+}
+
+func TestValidation(t *testing.T) {
+	client := startTestServer(t, NewMyServer(WithStreamInterval(0)),
+		grpc.ChainUnaryInterceptor(errorInterceptor{}.unary),
+		grpc.ChainStreamInterceptor(errorInterceptor{}.stream),
+	)
+	ctx := context.Background()
+
+	_, err := client.Hello(ctx, &hellopb.HelloRequest{Name: " "})
+	if stat := status.Convert(err); stat.Code() != codes.InvalidArgument || !hasFieldViolation(stat, "name") {
+		t.Errorf("empty name: %v, details %v", stat.Code(), stat.Details())
+	}
+
+	_, err = client.Hello(ctx, &hellopb.HelloRequest{Name: strings.Repeat("x", maxNameLength+1)})
+	if stat := status.Convert(err); stat.Code() != codes.ResourceExhausted || len(stat.Details()) != 1 {
+		t.Errorf("long name: %v, details %v", stat.Code(), stat.Details())
+	} else if _, ok := stat.Details()[0].(*errdetails.QuotaFailure); !ok {
+		t.Errorf("long name: detail %T, want *errdetails.QuotaFailure", stat.Details()[0])
+	}
+
+	bs, err := client.HelloBiStreams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.Send(&hellopb.HelloRequest{Name: "gopher"})
+	bs.Send(&hellopb.HelloRequest{Name: ""})
+	bs.CloseSend()
+	for err == nil {
+		_, err = bs.Recv()
+	}
+	if stat := status.Convert(err); stat.Code() != codes.InvalidArgument || !hasFieldViolation(stat, "requests[1].name") {
+		t.Errorf("bidi stream: %v, details %v", stat.Code(), stat.Details())
+	}
+}
+
+func hasFieldViolation(stat *status.Status, field string) bool {
+	for _, d := range stat.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				if v.GetField() == field {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func TestToStatusError(t *testing.T) {
+	stat := status.Convert(toStatusError(errors.New("disk full"), false))
+	if stat.Code() != codes.Internal || strings.Contains(stat.Message(), "disk full") || len(stat.Details()) != 0 {
+		t.Errorf("plain error became %v %q, details %v", stat.Code(), stat.Message(), stat.Details())
+	}
+
+	stat = status.Convert(toStatusError(errors.New("disk full"), true))
+	if len(stat.Details()) != 1 {
+		t.Fatalf("details = %v, want DebugInfo", stat.Details())
+	}
+	if d, ok := stat.Details()[0].(*errdetails.DebugInfo); !ok || d.GetDetail() != "disk full" {
+		t.Errorf("detail = %v", stat.Details()[0])
+	}
+
+	if code := status.Code(toStatusError(context.DeadlineExceeded, false)); code != codes.DeadlineExceeded {
+		t.Errorf("context.DeadlineExceeded became %v", code)
+	}
+}
+
+func TestErrorInterceptorLogs(t *testing.T) {
+	var buf bytes.Buffer
+	errs := errorInterceptor{logger: zerolog.New(&buf)}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(logging.RequestIDHeader, "req-1"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/myapp.GreetingService/Hello"}
+
+	_, err := errs.unary(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("disk full")
+	})
+	if stat := status.Convert(err); stat.Code() != codes.Internal || len(stat.Details()) != 0 {
+		t.Errorf("error = %v, details %v", err, stat.Details())
+	}
+	line := buf.String()
+	for _, want := range []string{`"error":"disk full"`, `"request_id":"req-1"`, `"method":"/myapp.GreetingService/Hello"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log %q lacks %s", line, want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// serverMetrics records every call the server handles. Labels follow the
+// names used by go-grpc-prometheus so existing dashboards keep working.
+type serverMetrics struct {
+	started        *prometheus.CounterVec
+	handled        *prometheus.CounterVec
+	handlingTime   *prometheus.HistogramVec
+	streamsRunning *prometheus.GaugeVec
+	msgReceived    *prometheus.CounterVec
+	msgSent        *prometheus.CounterVec
+	streamMessages *prometheus.HistogramVec
+}
+
+func newServerMetrics(reg prometheus.Registerer) *serverMetrics {
+	m := &serverMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_started_total",
+			Help: "RPCs started on the server.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "RPCs completed on the server, by status code.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"}),
+		handlingTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Time from the start of an RPC until the server finished it.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		streamsRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_streams_in_flight",
+			Help: "Streaming RPCs currently running on the server.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		msgReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_received_total",
+			Help: "Stream messages received by the server.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		msgSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_msg_sent_total",
+			Help: "Stream messages sent by the server.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		streamMessages: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_stream_messages",
+			Help:    "Messages exchanged over one stream, by direction.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}, []string{"grpc_type", "grpc_service", "grpc_method", "direction"}),
+	}
+	reg.MustRegister(
+		m.started,
+		m.handled,
+		m.handlingTime,
+		m.streamsRunning,
+		m.msgReceived,
+		m.msgSent,
+		m.streamMessages,
+	)
+	return m
+}
+
+// serveMetrics exposes the metrics in reg, along with the Go runtime and
+// process ones, at /metrics on addr.
+func serveMetrics(addr string, reg *prometheus.Registry) *http.Server {
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Serving metrics on %s/metrics", addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
+	return srv
+}
+
+// splitMethod turns "/myapp.GreetingService/Hello" into its service and
+// method names.
+func splitMethod(fullMethod string) (string, string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", "unknown"
+	}
+	return service, method
+}
+
+func streamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return "bidi_stream"
+	case info.IsClientStream:
+		return "client_stream"
+	}
+	return "server_stream"
+}
+
+func (m *serverMetrics) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	service, method := splitMethod(info.FullMethod)
+	m.started.WithLabelValues("unary", service, method).Inc()
+
+	start := time.Now()
+	res, err := handler(ctx, req)
+
+	m.handlingTime.WithLabelValues("unary", service, method).Observe(time.Since(start).Seconds())
+	m.handled.WithLabelValues("unary", service, method, status.Code(err).String()).Inc()
+	return res, err
+}
+
+func (m *serverMetrics) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	typ := streamType(info)
+	service, method := splitMethod(info.FullMethod)
+	m.started.WithLabelValues(typ, service, method).Inc()
+	running := m.streamsRunning.WithLabelValues(typ, service, method)
+	running.Inc()
+
+	wrapper := &metricsServerStream{
+		ServerStream: ss,
+		received:     m.msgReceived.WithLabelValues(typ, service, method),
+		sent:         m.msgSent.WithLabelValues(typ, service, method),
+	}
+	start := time.Now()
+	err := handler(srv, wrapper)
+
+	running.Dec()
+	m.handlingTime.WithLabelValues(typ, service, method).Observe(time.Since(start).Seconds())
+	m.handled.WithLabelValues(typ, service, method, status.Code(err).String()).Inc()
+	m.streamMessages.WithLabelValues(typ, service, method, "received").Observe(float64(wrapper.receivedCount))
+	m.streamMessages.WithLabelValues(typ, service, method, "sent").Observe(float64(wrapper.sentCount))
+	return err
+}
+
+// metricsServerStream counts the messages of one stream. gRPC does not
+// call SendMsg or RecvMsg of one stream concurrently in the same
+// direction, and the counts are read after the handler returns, so they
+// need no locking.
+type metricsServerStream struct {
+	grpc.ServerStream
+	received, sent prometheus.Counter
+
+	receivedCount, sentCount int
+}
+
+func (s *metricsServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.receivedCount++
+		s.received.Inc()
+	}
+	return err
+}
+
+func (s *metricsServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sentCount++
+		s.sent.Inc()
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := newServerMetrics(reg)
+	client := startTestServer(t, NewMyServer(WithStreamInterval(0)),
+		grpc.ChainUnaryInterceptor(m.unaryInterceptor, errorInterceptor{}.unary),
+		grpc.ChainStreamInterceptor(m.streamInterceptor, errorInterceptor{}.stream),
+	)
+	ctx := context.Background()
+
+	if _, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Hello(ctx, &hellopb.HelloRequest{}); err == nil {
+		t.Fatal("empty name accepted")
+	}
+
+	stream, err := client.HelloBiStreams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := stream.Send(&hellopb.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := testutil.ToFloat64(m.streamsRunning.WithLabelValues("bidi_stream", "myapp.GreetingService", "HelloBiStreams")); got != 1 {
+		t.Errorf("streams in flight = %v, want 1", got)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after CloseSend: %v", err)
+	}
+
+	handled := func(typ, method, code string) float64 {
+		return testutil.ToFloat64(m.handled.WithLabelValues(typ, "myapp.GreetingService", method, code))
+	}
+	if got := handled("unary", "Hello", "OK"); got != 1 {
+		t.Errorf("Hello OK = %v, want 1", got)
+	}
+	if got := handled("unary", "Hello", "InvalidArgument"); got != 1 {
+		t.Errorf("Hello InvalidArgument = %v, want 1", got)
+	}
+	if got := handled("bidi_stream", "HelloBiStreams", "OK"); got != 1 {
+		t.Errorf("HelloBiStreams OK = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.streamsRunning.WithLabelValues("bidi_stream", "myapp.GreetingService", "HelloBiStreams")); got != 0 {
+		t.Errorf("streams in flight = %v after the stream ended", got)
+	}
+	if got := testutil.ToFloat64(m.msgSent.WithLabelValues("bidi_stream", "myapp.GreetingService", "HelloBiStreams")); got != 3 {
+		t.Errorf("messages sent = %v, want 3", got)
+	}
+
+	// one stream that received 3 messages falls into the le="4" bucket
+	want := `
+# HELP grpc_server_stream_messages Messages exchanged over one stream, by direction.
+# TYPE grpc_server_stream_messages histogram
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="1"} 0
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="2"} 0
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="4"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="8"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="16"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="32"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="64"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="128"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="256"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="512"} 1
+grpc_server_stream_messages_bucket{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="+Inf"} 1
+grpc_server_stream_messages_sum{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream"} 3
+grpc_server_stream_messages_count{direction="received",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="1"} 0
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="2"} 0
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="4"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="8"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="16"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="32"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="64"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="128"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="256"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="512"} 1
+grpc_server_stream_messages_bucket{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream",le="+Inf"} 1
+grpc_server_stream_messages_sum{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream"} 3
+grpc_server_stream_messages_count{direction="sent",grpc_method="HelloBiStreams",grpc_service="myapp.GreetingService",grpc_type="bidi_stream"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "grpc_server_stream_messages"); err != nil {
+		t.Error(err)
+	}
+}
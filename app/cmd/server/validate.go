@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/pkg/logging"
+)
+
+const (
+	maxNameLength  = 64
+	maxStreamNames = 100
+)
+
+// validateName checks the name field of one request; field is its path in
+// the error details, e.g. "name" or "requests[2].name".
+func validateName(field, name string) error {
+	var desc string
+	switch {
+	case strings.TrimSpace(name) == "":
+		desc = "must not be empty"
+	case !utf8.ValidString(name):
+		desc = "must be valid UTF-8"
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		desc = "must not contain control characters"
+	case utf8.RuneCountInString(name) > maxNameLength:
+		return quotaError(field, fmt.Sprintf("must be at most %d characters, got %d", maxNameLength, utf8.RuneCountInString(name)))
+	default:
+		return nil
+	}
+
+	stat := status.New(codes.InvalidArgument, fmt.Sprintf("invalid %s: %s", field, desc))
+	stat, _ = stat.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: desc},
+		},
+	})
+	return stat.Err()
+}
+
+func quotaError(subject, desc string) error {
+	stat := status.New(codes.ResourceExhausted, fmt.Sprintf("%s %s", subject, desc))
+	stat, _ = stat.WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: subject, Description: desc},
+		},
+	})
+	return stat.Err()
+}
+
+// toStatusError makes sure every error leaving a handler carries a gRPC
+// status code. Errors without one become a bare Internal, so no server
+// detail reaches the client; debug adds their text as DebugInfo.
+func toStatusError(err error, debug bool) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	stat := status.New(codes.Internal, "internal error")
+	if debug {
+		stat, _ = stat.WithDetails(&errdetails.DebugInfo{
+			Detail: err.Error(),
+		})
+	}
+	return stat.Err()
+}
+
+// errorInterceptor applies toStatusError and logs the errors it hides. It
+// runs innermost, so the logger of the logging interceptor, which carries
+// the request ID, is in the context; without one, logger is used.
+type errorInterceptor struct {
+	logger zerolog.Logger
+	debug  bool
+}
+
+func (e errorInterceptor) convert(ctx context.Context, method string, err error) error {
+	serr := toStatusError(err, e.debug)
+	if _, ok := status.FromError(err); !ok && status.Code(serr) == codes.Internal {
+		l := zerolog.Ctx(ctx)
+		if l.GetLevel() == zerolog.Disabled {
+			c := e.logger.With().Str("method", method)
+			md, _ := metadata.FromIncomingContext(ctx)
+			if ids := md.Get(logging.RequestIDHeader); len(ids) > 0 {
+				c = c.Str("request_id", ids[0])
+			}
+			logger := c.Logger()
+			l = &logger
+		}
+		l.Error().Err(err).Msg("internal error")
+	}
+	return serr
+}
+
+func (e errorInterceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	return res, e.convert(ctx, info.FullMethod, err)
+}
+
+func (e errorInterceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return e.convert(ss.Context(), info.FullMethod, handler(srv, ss))
+}
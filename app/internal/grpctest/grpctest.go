@@ -0,0 +1,183 @@
+// Package grpctest serves a GreetingService for tests, over an in-memory
+// listener or a loopback port, and stops it when the test ends.
+package grpctest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// Greeter answers every method like the real server does, rejecting empty
+// names with InvalidArgument. Tests embed it to override single methods.
+type Greeter struct {
+	hellopb.UnimplementedGreetingServiceServer
+	// Check, if set, is called with every non-empty name; an error ends
+	// the call with it.
+	Check func(ctx context.Context, name string) error
+}
+
+// Greeting is what Greeter answers name with.
+func Greeting(name string) string {
+	return "Hello, " + name + "!"
+}
+
+func (g Greeter) check(ctx context.Context, name string) error {
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	if g.Check != nil {
+		return g.Check(ctx, name)
+	}
+	return nil
+}
+
+func (g Greeter) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	if err := g.check(ctx, req.GetName()); err != nil {
+		return nil, err
+	}
+	return &hellopb.HelloResponse{Message: Greeting(req.GetName())}, nil
+}
+
+// HelloServerStream sends three numbered greetings.
+func (g Greeter) HelloServerStream(req *hellopb.HelloRequest, stream hellopb.GreetingService_HelloServerStreamServer) error {
+	if err := g.check(stream.Context(), req.GetName()); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&hellopb.HelloResponse{Message: fmt.Sprintf("[%d] %s", i, Greeting(req.GetName()))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HelloClientStream greets all names at once, joined with " and ".
+func (g Greeter) HelloClientStream(stream hellopb.GreetingService_HelloClientStreamServer) error {
+	var names []string
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&hellopb.HelloResponse{Message: Greeting(strings.Join(names, " and "))})
+		}
+		if err != nil {
+			return err
+		}
+		if err := g.check(stream.Context(), req.GetName()); err != nil {
+			return err
+		}
+		names = append(names, req.GetName())
+	}
+}
+
+func (g Greeter) HelloBiStreams(stream hellopb.GreetingService_HelloBiStreamsServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := g.check(stream.Context(), req.GetName()); err != nil {
+			return err
+		}
+		if err := stream.Send(&hellopb.HelloResponse{Message: Greeting(req.GetName())}); err != nil {
+			return err
+		}
+	}
+}
+
+type options struct {
+	server   []grpc.ServerOption
+	dial     []grpc.DialOption
+	register []func(*grpc.Server)
+}
+
+type Option func(*options)
+
+// ServerOptions are passed to grpc.NewServer, e.g. interceptors.
+func ServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) { o.server = append(o.server, opts...) }
+}
+
+// DialOptions are passed to grpc.Dial by Dial and Conn.
+func DialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) { o.dial = append(o.dial, opts...) }
+}
+
+// Register is called with the server before it starts, to add services
+// such as health or to keep the server for stopping it early.
+func Register(f func(s *grpc.Server)) Option {
+	return func(o *options) { o.register = append(o.register, f) }
+}
+
+func serve(t testing.TB, l net.Listener, srv hellopb.GreetingServiceServer, o *options) {
+	s := grpc.NewServer(o.server...)
+	hellopb.RegisterGreetingServiceServer(s, srv)
+	for _, f := range o.register {
+		f(s)
+	}
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+}
+
+// Dial serves srv on an in-memory listener and returns a client of it.
+func Dial(t testing.TB, srv hellopb.GreetingServiceServer, opts ...Option) hellopb.GreetingServiceClient {
+	t.Helper()
+	return hellopb.NewGreetingServiceClient(Conn(t, srv, opts...))
+}
+
+// Conn is Dial for tests that need the connection itself, e.g. to call
+// services added with Register.
+func Conn(t testing.TB, srv hellopb.GreetingServiceServer, opts ...Option) *grpc.ClientConn {
+	t.Helper()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	l := bufconn.Listen(1 << 20)
+	serve(t, l, srv, &o)
+
+	conn, err := grpc.Dial("bufnet", append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, o.dial...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Listen serves srv on a loopback port, for tests that dial by address,
+// and returns the address. DialOptions are ignored.
+func Listen(t testing.TB, srv hellopb.GreetingServiceServer, opts ...Option) string {
+	t.Helper()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, srv, &o)
+	return l.Addr().String()
+}
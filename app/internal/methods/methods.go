@@ -0,0 +1,18 @@
+// Package methods matches gRPC full method names against the lists that
+// interceptors take to single out methods.
+package methods
+
+import "strings"
+
+// Match reports whether method is in patterns. A pattern is either a full
+// method name, such as "/myapp.GreetingService/Hello", or a prefix ending
+// in "/", such as "/grpc.health.v1.Health/", matching every method of
+// that service.
+func Match(method string, patterns []string) bool {
+	for _, p := range patterns {
+		if method == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(method, p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package methods
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	patterns := []string{"/myapp.GreetingService/Hello", "/grpc.health.v1.Health/"}
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{"/myapp.GreetingService/Hello", true},
+		{"/myapp.GreetingService/HelloBiStreams", false},
+		{"/grpc.health.v1.Health/Check", true},
+		{"/grpc.health.v1.HealthX/Check", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.method, patterns); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+	if Match("/myapp.GreetingService/Hello", nil) {
+		t.Error("Match() with no patterns = true")
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKeys authenticates static keys. Keys are kept as SHA-256 hashes so
+// the lookup does not compare secrets byte by byte.
+type APIKeys struct {
+	subjects map[[sha256.Size]byte]string
+}
+
+// NewAPIKeys maps each key to the subject it authenticates.
+func NewAPIKeys(keys map[string]string) *APIKeys {
+	k := &APIKeys{subjects: make(map[[sha256.Size]byte]string, len(keys))}
+	for key, subject := range keys {
+		k.subjects[sha256.Sum256([]byte(key))] = subject
+	}
+	return k
+}
+
+// LoadAPIKeys reads a file with one "subject key" pair per line. Blank
+// lines and lines starting with # are skipped.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"subject key\"", path, line)
+		}
+		if _, ok := keys[fields[1]]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key", path, line)
+		}
+		keys[fields[1]] = fields[0]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return NewAPIKeys(keys), nil
+}
+
+func (k *APIKeys) Authenticate(ctx context.Context, token string) (Identity, error) {
+	subject, ok := k.subjects[sha256.Sum256([]byte(token))]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	return Identity{Subject: subject, Method: "api-key"}, nil
+}
@@ -0,0 +1,133 @@
+// Package auth authenticates gRPC calls with bearer tokens sent in the
+// "authorization" metadata, either static API keys or HMAC-signed JWTs.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/methods"
+)
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Identity is the authenticated caller of an RPC.
+type Identity struct {
+	Subject string
+	// Method is how the caller was authenticated, "api-key" or "jwt".
+	Method string
+}
+
+type identityKey struct{}
+
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller stored by the server interceptors.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Authenticator checks a bearer token. It returns ErrInvalidToken, possibly
+// wrapped, for tokens it does not accept.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Identity, error)
+}
+
+// Chain accepts a token when any of the authenticators does.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, token string) (Identity, error) {
+	err := ErrInvalidToken
+	for _, a := range c {
+		id, aerr := a.Authenticate(ctx, token)
+		if aerr == nil {
+			return id, nil
+		}
+		if !errors.Is(aerr, ErrInvalidToken) {
+			err = aerr
+		}
+	}
+	return Identity{}, err
+}
+
+// TokenFromContext extracts the bearer token from incoming metadata.
+func TokenFromContext(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", ErrMissingToken
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") || strings.TrimSpace(token) == "" {
+		return "", ErrMissingToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+func authenticate(ctx context.Context, a Authenticator) (context.Context, error) {
+	token, err := TokenFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	id, err := a.Authenticate(ctx, token)
+	if err != nil {
+		// the reason stays in the server log; the caller only learns it
+		// failed
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("authentication failed")
+		return nil, status.Error(codes.Unauthenticated, ErrInvalidToken.Error())
+	}
+	return NewContext(ctx, id), nil
+}
+
+// UnaryServerInterceptor rejects calls without a valid token with
+// Unauthenticated. Methods matching public, see methods.Match, are let
+// through without a token.
+func UnaryServerInterceptor(a Authenticator, public ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if methods.Match(info.FullMethod, public) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(a Authenticator, public ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if methods.Match(info.FullMethod, public) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// whoami greets the authenticated caller instead of the requested name.
+type whoami struct {
+	grpctest.Greeter
+}
+
+func (whoami) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	id, _ := FromContext(ctx)
+	return &hellopb.HelloResponse{Message: id.Subject + "/" + id.Method}, nil
+}
+
+func (whoami) HelloBiStreams(stream hellopb.GreetingService_HelloBiStreamsServer) error {
+	id, _ := FromContext(stream.Context())
+	return stream.Send(&hellopb.HelloResponse{Message: id.Subject + "/" + id.Method})
+}
+
+func dial(t *testing.T, a Authenticator, public []string, opts ...grpc.DialOption) hellopb.GreetingServiceClient {
+	t.Helper()
+
+	return grpctest.Dial(t, whoami{},
+		grpctest.ServerOptions(
+			grpc.UnaryInterceptor(UnaryServerInterceptor(a, public...)),
+			grpc.StreamInterceptor(StreamServerInterceptor(a, public...)),
+		),
+		grpctest.DialOptions(opts...),
+	)
+}
+
+func withToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(BearerToken{Token: token, AllowInsecure: true})
+}
+
+func TestInterceptors(t *testing.T) {
+	secret := []byte("test secret")
+	jwt, err := SignJWT(secret, Claims{Subject: "bob", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Chain{
+		NewAPIKeys(map[string]string{"key-1": "alice"}),
+		&JWTVerifier{Secret: secret},
+	}
+
+	tests := []struct {
+		name string
+		opts []grpc.DialOption
+		want string
+		code codes.Code
+	}{
+		{name: "api key", opts: []grpc.DialOption{withToken("key-1")}, want: "alice/api-key"},
+		{name: "jwt", opts: []grpc.DialOption{withToken(jwt)}, want: "bob/jwt"},
+		{name: "no token", code: codes.Unauthenticated},
+		{name: "unknown key", opts: []grpc.DialOption{withToken("key-2")}, code: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dial(t, a, nil, tt.opts...)
+			ctx := context.Background()
+
+			res, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "x"})
+			if status.Code(err) != tt.code {
+				t.Fatalf("Hello: code = %v, want %v (%v)", status.Code(err), tt.code, err)
+			}
+			if err == nil && res.GetMessage() != tt.want {
+				t.Errorf("Hello: caller = %q, want %q", res.GetMessage(), tt.want)
+			}
+
+			stream, err := client.HelloBiStreams(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err = stream.Recv()
+			if status.Code(err) != tt.code {
+				t.Fatalf("HelloBiStreams: code = %v, want %v (%v)", status.Code(err), tt.code, err)
+			}
+			if err == nil && res.GetMessage() != tt.want {
+				t.Errorf("HelloBiStreams: caller = %q, want %q", res.GetMessage(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectionLogged(t *testing.T) {
+	secret := []byte("test secret")
+	expired, err := SignJWT(secret, Claims{Subject: "bob", ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	ctx := zerolog.New(&buf).WithContext(context.Background())
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+expired))
+	info := &grpc.UnaryServerInfo{FullMethod: "/myapp.GreetingService/Hello"}
+
+	_, err = UnaryServerInterceptor(&JWTVerifier{Secret: secret})(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) {
+		t.Fatal("handler called")
+		return nil, nil
+	})
+	if stat := status.Convert(err); stat.Code() != codes.Unauthenticated || stat.Message() != ErrInvalidToken.Error() {
+		t.Errorf("err = %v, want a bare %q", err, ErrInvalidToken)
+	}
+	if line := buf.String(); !strings.Contains(line, "expired") {
+		t.Errorf("log %q lacks the reason", line)
+	}
+}
+
+func TestPublicMethods(t *testing.T) {
+	client := dial(t, NewAPIKeys(nil), []string{"/myapp.GreetingService/Hello"})
+	if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "x"}); err != nil {
+		t.Errorf("public Hello: %v", err)
+	}
+	stream, err := client.HelloBiStreams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("HelloBiStreams: %v, want Unauthenticated", err)
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Date(2023, time.January, 4, 12, 0, 0, 0, time.UTC)
+	v := &JWTVerifier{
+		Secret:   secret,
+		Issuer:   "go_paradise",
+		Audience: "greeter",
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	}
+	valid := Claims{
+		Subject:   "bob",
+		Issuer:    "go_paradise",
+		Audience:  Audience{"other", "greeter"},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+	sign := func(c Claims) string {
+		token, err := SignJWT(secret, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	if _, err := v.Verify(sign(valid)); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+
+	expired := valid
+	expired.ExpiresAt = now.Add(-2 * time.Minute).Unix()
+	skewed := valid
+	skewed.ExpiresAt = now.Add(-30 * time.Second).Unix()
+	early := valid
+	early.NotBefore = now.Add(time.Hour).Unix()
+	issuer := valid
+	issuer.Issuer = "someone else"
+	audience := valid
+	audience.Audience = Audience{"other"}
+	noSubject := valid
+	noSubject.Subject = ""
+	noExpiry := valid
+	noExpiry.ExpiresAt = 0
+
+	forged, err := SignJWT([]byte("wrong secret"), valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(sign(valid), ".")
+	none := encoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"within leeway", sign(skewed), true},
+		{"expired", sign(expired), false},
+		{"not before", sign(early), false},
+		{"issuer", sign(issuer), false},
+		{"audience", sign(audience), false},
+		{"no subject", sign(noSubject), false},
+		{"no expiry", sign(noExpiry), false},
+		{"forged", forged, false},
+		{"alg none", none, false},
+		{"malformed", "abc", false},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(tt.token)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: %v is not ErrInvalidToken", tt.name, err)
+		}
+	}
+
+	v.AllowNoExpiry = true
+	if _, err := v.Verify(sign(noExpiry)); err != nil {
+		t.Errorf("no expiry with AllowNoExpiry: %v", err)
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# subject key\nalice key-1\n\nbob key-2\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := keys.Authenticate(context.Background(), "key-2")
+	if err != nil || id.Subject != "bob" {
+		t.Errorf("key-2: %+v, %v", id, err)
+	}
+
+	if err := os.WriteFile(path, []byte("alice key-1 extra\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAPIKeys(path); err == nil {
+		t.Error("no error for malformed line")
+	}
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"context"
+)
+
+// BearerToken sends a token with every call, for use with
+// grpc.WithPerRPCCredentials. Tokens are only sent over TLS unless
+// AllowInsecure is set.
+type BearerToken struct {
+	Token         string
+	AllowInsecure bool
+}
+
+func (t BearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.Token}, nil
+}
+
+func (t BearerToken) RequireTransportSecurity() bool {
+	return !t.AllowInsecure
+}
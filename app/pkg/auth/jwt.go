@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims are the registered JWT claims the verifier looks at.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// Audience is the "aud" claim, which may be a single string or a list.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// SignJWT returns an HS256 token for claims.
+func SignJWT(secret []byte, claims Claims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	return unsigned + "." + encoding.EncodeToString(sign(secret, unsigned)), nil
+}
+
+func sign(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+// JWTVerifier authenticates HS256 tokens signed with Secret. Issuer and
+// Audience are checked when set; exp and nbf always are, allowing Leeway
+// for clock skew. Tokens without exp are rejected unless AllowNoExpiry is
+// set, since a leaked one would never stop working.
+type JWTVerifier struct {
+	Secret        []byte
+	Issuer        string
+	Audience      string
+	Leeway        time.Duration
+	AllowNoExpiry bool
+	Now           func() time.Time
+}
+
+func (v *JWTVerifier) Authenticate(ctx context.Context, token string) (Identity, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Subject: claims.Subject, Method: "jwt"}, nil
+}
+
+// Verify checks the signature and claims of token.
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed jwt", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	// only the algorithm we sign with, never "none" or an asymmetric one
+	if header.Alg != "HS256" {
+		return Claims{}, fmt.Errorf("%w: unexpected algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, sign(v.Secret, parts[0]+"."+parts[1])) {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	t := now()
+	switch {
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case claims.ExpiresAt == 0 && !v.AllowNoExpiry:
+		return Claims{}, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	case claims.ExpiresAt != 0 && t.After(time.Unix(claims.ExpiresAt, 0).Add(v.Leeway)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != 0 && t.Before(time.Unix(claims.NotBefore, 0).Add(-v.Leeway)):
+		return Claims{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case v.Issuer != "" && claims.Issuer != v.Issuer:
+		return Claims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	case v.Audience != "" && !claims.Audience.contains(v.Audience):
+		return Claims{}, fmt.Errorf("%w: not meant for %q", ErrInvalidToken, v.Audience)
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := encoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HTTPStatus maps a gRPC code to the HTTP status used for it, following
+// the table of google.rpc.Code.
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// errorBody renders err as a google.rpc.Status, details included, which is
+// the body of every error response.
+func errorBody(err error) []byte {
+	b, merr := protojson.Marshal(status.Convert(err).Proto())
+	if merr != nil {
+		return []byte(`{"code":2,"message":"cannot render error"}`)
+	}
+	return b
+}
+
+// writeError sends err with the HTTP status matching its gRPC code. A
+// RetryInfo detail becomes a Retry-After header.
+func writeError(w http.ResponseWriter, err error) {
+	stat := status.Convert(err)
+	for _, d := range stat.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			secs := int(info.GetRetryDelay().AsDuration().Seconds() + 0.999)
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+		}
+	}
+	writeStatus(w, HTTPStatus(stat.Code()), err)
+}
+
+func writeStatus(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(errorBody(err))
+}
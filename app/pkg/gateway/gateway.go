@@ -0,0 +1,309 @@
+// Package gateway exposes GreetingService over HTTP/JSON for clients that
+// cannot speak gRPC:
+//
+//	POST /v1/hello                unary Hello
+//	GET|POST /v1/hello/stream     HelloServerStream as server-sent events
+//	                              or newline delimited JSON
+//	POST /v1/hello/collect        HelloClientStream from a JSON array
+//	GET /v1/hello/chat            HelloBiStreams over a WebSocket
+//
+// Messages use the protobuf JSON mapping and errors are google.rpc.Status
+// objects sent with the HTTP status matching their gRPC code.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+const maxBodySize = 1 << 20
+
+// forwardedHeaders are copied from the HTTP request into gRPC metadata.
+var forwardedHeaders = []string{
+	"Authorization",
+	"X-Request-Id",
+	"Traceparent",
+	"Tracestate",
+}
+
+type Gateway struct {
+	client hellopb.GreetingServiceClient
+	mux    *http.ServeMux
+}
+
+func New(client hellopb.GreetingServiceClient) *Gateway {
+	g := &Gateway{client: client, mux: http.NewServeMux()}
+	g.mux.HandleFunc("/v1/hello", g.hello)
+	g.mux.HandleFunc("/v1/hello/stream", g.serverStream)
+	g.mux.HandleFunc("/v1/hello/collect", g.clientStream)
+	g.mux.Handle("/v1/hello/chat", websocket.Server{Handler: g.chat, Handshake: checkOrigin})
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// outgoing returns the context of the gRPC call made for r.
+func outgoing(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for _, h := range forwardedHeaders {
+		if v := r.Header.Get(h); v != "" {
+			md.Set(strings.ToLower(h), v)
+		}
+	}
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// copyHeader returns the request ID of the gRPC response to the caller.
+func copyHeader(w http.ResponseWriter, md metadata.MD) {
+	if v := md.Get("x-request-id"); len(v) > 0 {
+		w.Header().Set("X-Request-Id", v[0])
+	}
+}
+
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeStatus(w, http.StatusMethodNotAllowed, status.Errorf(codes.Unimplemented, "%s is not allowed, use %s", r.Method, strings.Join(methods, " or ")))
+	return false
+}
+
+// readRequest decodes a HelloRequest from the body of r.
+func readRequest(w http.ResponseWriter, r *http.Request) (*hellopb.HelloRequest, error) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, status.Errorf(codes.ResourceExhausted, "body exceeds %d bytes", maxBodySize)
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "reading body: %v", err)
+	}
+	var req hellopb.HelloRequest
+	if err := protojson.Unmarshal(b, &req); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid body: %v", err)
+	}
+	return &req, nil
+}
+
+func writeMessage(w http.ResponseWriter, code int, m proto.Message) {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "rendering response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func (g *Gateway) hello(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	req, err := readRequest(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var header metadata.MD
+	res, err := g.client.Hello(outgoing(r), req, grpc.Header(&header))
+	copyHeader(w, header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
+
+// serverStream answers with server-sent events when the client accepts
+// text/event-stream, and with one JSON object per line otherwise. The
+// request is the JSON body of a POST or the name parameter of a GET, which
+// is what EventSource can send.
+func (g *Gateway) serverStream(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	req := &hellopb.HelloRequest{Name: r.URL.Query().Get("name")}
+	if r.Method == http.MethodPost {
+		var err error
+		if req, err = readRequest(w, r); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	stream, err := g.client.HelloServerStream(outgoing(r), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// errors before the first message still get a proper status code
+	first, err := stream.Recv()
+	if header, herr := stream.Header(); herr == nil {
+		copyHeader(w, header)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, err)
+		return
+	}
+
+	var out streamWriter
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		out = &sseWriter{w: w}
+	} else {
+		out = &ndjsonWriter{w: w}
+	}
+	out.start()
+	flush(w)
+
+	for res := first; err == nil; res, err = stream.Recv() {
+		if werr := out.message(res); werr != nil {
+			// the client went away; returning cancels r.Context() and
+			// with it the stream
+			return
+		}
+		flush(w)
+	}
+	if !errors.Is(err, io.EOF) {
+		out.error(err)
+	} else {
+		out.end()
+	}
+	flush(w)
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type streamWriter interface {
+	start()
+	message(m proto.Message) error
+	// error reports a failure after the response status was sent.
+	error(err error)
+	end()
+}
+
+type sseWriter struct {
+	w http.ResponseWriter
+}
+
+func (s *sseWriter) start() {
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *sseWriter) message(m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "event: message\ndata: %s\n\n", b)
+	return err
+}
+
+func (s *sseWriter) error(err error) {
+	fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", errorBody(err))
+}
+
+func (s *sseWriter) end() {
+	fmt.Fprint(s.w, "event: end\ndata: {}\n\n")
+}
+
+// ndjsonWriter writes {"result": ...} lines and a final {"error": ...}
+// line when the stream fails.
+type ndjsonWriter struct {
+	w http.ResponseWriter
+}
+
+func (n *ndjsonWriter) start() {
+	n.w.Header().Set("Content-Type", "application/x-ndjson")
+	n.w.WriteHeader(http.StatusOK)
+}
+
+func (n *ndjsonWriter) message(m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(n.w, "{\"result\":%s}\n", b)
+	return err
+}
+
+func (n *ndjsonWriter) error(err error) {
+	fmt.Fprintf(n.w, "{\"error\":%s}\n", errorBody(err))
+}
+
+func (n *ndjsonWriter) end() {}
+
+// clientStream sends every element of a JSON array body as one request
+// of the stream, without reading the whole array first.
+func (g *Gateway) clientStream(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	ctx, cancel := context.WithCancel(outgoing(r))
+	defer cancel()
+
+	stream, err := g.client.HelloClientStream(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		writeError(w, status.Error(codes.InvalidArgument, "body must be a JSON array of requests"))
+		return
+	}
+	for i := 0; dec.More(); i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid request %d: %v", i, err))
+			return
+		}
+		var req hellopb.HelloRequest
+		if err := protojson.Unmarshal(raw, &req); err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid request %d: %v", i, err))
+			return
+		}
+		if err := stream.Send(&req); err != nil {
+			// the server ended the stream; its status comes from CloseAndRecv
+			break
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if header, herr := stream.Header(); herr == nil {
+		copyHeader(w, header)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, res)
+}
@@ -0,0 +1,322 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// greeter fails on the names "", "busy" and "fail", and answers "whoami"
+// with the authorization metadata it received.
+type greeter struct {
+	grpctest.Greeter
+}
+
+func newGreeter() greeter {
+	return greeter{grpctest.Greeter{Check: func(_ context.Context, name string) error {
+		if name == "busy" {
+			stat, _ := status.New(codes.ResourceExhausted, "slow down").WithDetails(&errdetails.RetryInfo{
+				RetryDelay: durationpb.New(1500 * time.Millisecond),
+			})
+			return stat.Err()
+		}
+		return nil
+	}}}
+}
+
+func (g greeter) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	res, err := g.Greeter.Hello(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", "req-1"))
+	if req.GetName() == "whoami" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		return &hellopb.HelloResponse{Message: strings.Join(md.Get("authorization"), ",")}, nil
+	}
+	return res, nil
+}
+
+func (g greeter) HelloServerStream(req *hellopb.HelloRequest, stream hellopb.GreetingService_HelloServerStreamServer) error {
+	if req.GetName() != "fail" {
+		return g.Greeter.HelloServerStream(req, stream)
+	}
+	if err := stream.Send(&hellopb.HelloResponse{Message: "[0] Hello, fail!"}); err != nil {
+		return err
+	}
+	return status.Error(codes.Internal, "broken")
+}
+
+func startGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(New(grpctest.Dial(t, newGreeter())))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type errorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func post(t *testing.T, url, contentType, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, compact(t, string(b))
+}
+
+// compact strips the whitespace protojson randomly adds, line by line so
+// NDJSON bodies stay one message per line.
+func compact(t *testing.T, body string) string {
+	t.Helper()
+	lines := strings.SplitAfter(body, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || !json.Valid([]byte(line)) {
+			continue
+		}
+		var b bytes.Buffer
+		if err := json.Compact(&b, []byte(strings.TrimSuffix(line, "\n"))); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(line, "\n") {
+			b.WriteByte('\n')
+		}
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "")
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := map[codes.Code]int{
+		codes.OK:                http.StatusOK,
+		codes.InvalidArgument:   http.StatusBadRequest,
+		codes.Unauthenticated:   http.StatusUnauthorized,
+		codes.PermissionDenied:  http.StatusForbidden,
+		codes.NotFound:          http.StatusNotFound,
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+		codes.DeadlineExceeded:  http.StatusGatewayTimeout,
+		codes.Internal:          http.StatusInternalServerError,
+		codes.Code(99):          http.StatusInternalServerError,
+	}
+	for code, want := range tests {
+		if got := HTTPStatus(code); got != want {
+			t.Errorf("HTTPStatus(%v) = %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	srv := startGateway(t)
+	url := srv.URL + "/v1/hello"
+
+	res, body := post(t, url, "application/json", `{"name":"gopher"}`)
+	if res.StatusCode != http.StatusOK || body != `{"message":"Hello, gopher!"}` {
+		t.Errorf("got %d %s", res.StatusCode, body)
+	}
+	if got := res.Header.Get("X-Request-Id"); got != "req-1" {
+		t.Errorf("X-Request-Id = %q, want the one from the gRPC header", got)
+	}
+
+	_, body = post(t, url, "application/json", `{"name":"whoami"}`, "Authorization", "Bearer key-1")
+	if body != `{"message":"Bearer key-1"}` {
+		t.Errorf("authorization not forwarded: %s", body)
+	}
+
+	tests := []struct {
+		body       string
+		status     int
+		code       codes.Code
+		retryAfter string
+	}{
+		{`{"name":""}`, http.StatusBadRequest, codes.InvalidArgument, ""},
+		{`{"name":"busy"}`, http.StatusTooManyRequests, codes.ResourceExhausted, "2"},
+		{`{"nom":"gopher"}`, http.StatusBadRequest, codes.InvalidArgument, ""},
+		{`not json`, http.StatusBadRequest, codes.InvalidArgument, ""},
+	}
+	for _, tt := range tests {
+		res, body := post(t, url, "application/json", tt.body)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.body, res.StatusCode, tt.status)
+		}
+		var e errorResponse
+		if err := json.Unmarshal([]byte(body), &e); err != nil || codes.Code(e.Code) != tt.code {
+			t.Errorf("%s: body = %s, want code %d", tt.body, body, tt.code)
+		}
+		if got := res.Header.Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", tt.body, got, tt.retryAfter)
+		}
+	}
+
+	get, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get.Body.Close()
+	if get.StatusCode != http.StatusMethodNotAllowed || get.Header.Get("Allow") != "POST" {
+		t.Errorf("GET: %d, Allow %q", get.StatusCode, get.Header.Get("Allow"))
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	srv := startGateway(t)
+
+	t.Run("ndjson", func(t *testing.T) {
+		res, body := post(t, srv.URL+"/v1/hello/stream", "application/json", `{"name":"gopher"}`)
+		if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		want := `{"result":{"message":"[0] Hello, gopher!"}}
+{"result":{"message":"[1] Hello, gopher!"}}
+{"result":{"message":"[2] Hello, gopher!"}}
+`
+		if body != want {
+			t.Errorf("body:\n%s\nwant:\n%s", body, want)
+		}
+	})
+
+	t.Run("error mid-stream", func(t *testing.T) {
+		res, body := post(t, srv.URL+"/v1/hello/stream", "application/json", `{"name":"fail"}`)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, headers were sent with the first message", res.StatusCode)
+		}
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[1], `{"error":{"code":13`) {
+			t.Errorf("body = %s", body)
+		}
+	})
+
+	t.Run("error before the first message", func(t *testing.T) {
+		res, _ := post(t, srv.URL+"/v1/hello/stream", "application/json", `{"name":""}`)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", res.StatusCode)
+		}
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/hello/stream?name=gopher", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+
+		var events []string
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			if event := strings.TrimPrefix(sc.Text(), "event: "); event != sc.Text() {
+				events = append(events, event)
+			}
+		}
+		if got := strings.Join(events, ","); got != "message,message,message,end" {
+			t.Errorf("events = %s", got)
+		}
+	})
+}
+
+func TestClientStream(t *testing.T) {
+	srv := startGateway(t)
+	url := srv.URL + "/v1/hello/collect"
+
+	res, body := post(t, url, "application/json", `[{"name":"alice"},{"name":"bob"}]`)
+	if res.StatusCode != http.StatusOK || body != `{"message":"Hello, alice and bob!"}` {
+		t.Errorf("got %d %s", res.StatusCode, body)
+	}
+
+	res, _ = post(t, url, "application/json", `[{"name":"alice"},{"name":""}]`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid name: status = %d, want 400", res.StatusCode)
+	}
+	res, _ = post(t, url, "application/json", `{"name":"alice"}`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("object body: status = %d, want 400", res.StatusCode)
+	}
+}
+
+func TestChat(t *testing.T) {
+	srv := startGateway(t)
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/hello/chat"
+
+	ws, err := websocket.Dial(wsURL, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := websocket.Message.Send(ws, fmt.Sprintf(`{"name":%q}`, name)); err != nil {
+			t.Fatal(err)
+		}
+		var frame map[string]map[string]interface{}
+		if err := websocket.JSON.Receive(ws, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if got := frame["result"]["message"]; got != "Hello, "+name+"!" {
+			t.Errorf("frame = %v", frame)
+		}
+	}
+
+	if err := websocket.Message.Send(ws, `{"name":""}`); err != nil {
+		t.Fatal(err)
+	}
+	var frame map[string]map[string]interface{}
+	if err := websocket.JSON.Receive(ws, &frame); err != nil {
+		t.Fatal(err)
+	}
+	if code := frame["error"]["code"]; code != float64(codes.InvalidArgument) {
+		t.Errorf("frame = %v, want an InvalidArgument error", frame)
+	}
+	if err := websocket.JSON.Receive(ws, &frame); !errors.Is(err, io.EOF) {
+		t.Errorf("socket still open after the error: %v", err)
+	}
+}
+
+func TestChatRejectsOtherOrigins(t *testing.T) {
+	srv := startGateway(t)
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/hello/chat"
+	if ws, err := websocket.Dial(wsURL, "", "http://evil.example"); err == nil {
+		ws.Close()
+		t.Error("cross-origin handshake accepted")
+	}
+}
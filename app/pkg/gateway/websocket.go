@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// checkOrigin accepts clients without an Origin header and browsers on the
+// gateway's own host, which keeps other sites from using a visitor's
+// credentials.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return errors.New("cross-origin WebSocket rejected")
+	}
+	config.Origin = u
+	return nil
+}
+
+// chatFrame is one text frame sent by the gateway: a response, or the final
+// status when the stream failed.
+type chatFrame struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// chat runs HelloBiStreams over a WebSocket. Every text frame from the
+// client is a HelloRequest; every response comes back as a
+// {"result": HelloResponse} frame. When the stream fails an
+// {"error": Status} frame is sent before the socket is closed, and when
+// the client closes its side the gateway half-closes the stream.
+func (g *Gateway) chat(ws *websocket.Conn) {
+	defer ws.Close()
+
+	ctx, cancel := context.WithCancel(outgoing(ws.Request()))
+	defer cancel()
+	stream, err := g.client.HelloBiStreams(ctx)
+	if err != nil {
+		websocket.JSON.Send(ws, chatFrame{Error: errorBody(err)})
+		return
+	}
+
+	go func() {
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				stream.CloseSend()
+				return
+			}
+			var req hellopb.HelloRequest
+			if err := protojson.Unmarshal([]byte(msg), &req); err != nil {
+				websocket.JSON.Send(ws, chatFrame{Error: errorBody(status.Errorf(codes.InvalidArgument, "invalid request: %v", err))})
+				cancel()
+				return
+			}
+			if err := stream.Send(&req); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				websocket.JSON.Send(ws, chatFrame{Error: errorBody(err)})
+			}
+			return
+		}
+		b, err := protojson.Marshal(res)
+		if err != nil {
+			return
+		}
+		if err := websocket.JSON.Send(ws, chatFrame{Result: b}); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package lb
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// LeastRequest is the name of the least-request balancer in service
+// configs: {"loadBalancingConfig": [{"least_request": {}}]}.
+const LeastRequest = "least_request"
+
+// leastRequestBuilder gives each channel its own in-flight counters, which
+// base.NewBalancerBuilder would otherwise share through one PickerBuilder.
+type leastRequestBuilder struct{}
+
+func (leastRequestBuilder) Name() string { return LeastRequest }
+
+func (leastRequestBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &leastRequestPickerBuilder{inflight: map[balancer.SubConn]*int64{}}
+	return base.NewBalancerBuilder(LeastRequest, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+// leastRequestPickerBuilder keeps the number of calls in flight on each
+// backend across pickers, which are rebuilt whenever a backend changes
+// state.
+type leastRequestPickerBuilder struct {
+	mu       sync.Mutex
+	inflight map[balancer.SubConn]*int64
+}
+
+func (b *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inflight := make(map[balancer.SubConn]*int64, len(info.ReadySCs))
+	p := &leastRequestPicker{}
+	for sc := range info.ReadySCs {
+		n, ok := b.inflight[sc]
+		if !ok {
+			n = new(int64)
+		}
+		inflight[sc] = n
+		p.backends = append(p.backends, backend{sc, n})
+	}
+	b.inflight = inflight
+	return p
+}
+
+type backend struct {
+	sc       balancer.SubConn
+	inflight *int64
+}
+
+// leastRequestPicker picks the less busy of two random backends, which
+// spreads load nearly as well as scanning them all without sending every
+// new call to the same idle one.
+type leastRequestPicker struct {
+	backends []backend
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	b := p.backends[0]
+	if n := len(p.backends); n > 1 {
+		i := rand.Intn(n)
+		j := rand.Intn(n - 1)
+		if j >= i {
+			j++
+		}
+		b = p.backends[i]
+		if other := p.backends[j]; atomic.LoadInt64(other.inflight) < atomic.LoadInt64(b.inflight) {
+			b = other
+		}
+	}
+
+	atomic.AddInt64(b.inflight, 1)
+	return balancer.PickResult{
+		SubConn: b.sc,
+		Done:    func(balancer.DoneInfo) { atomic.AddInt64(b.inflight, -1) },
+	}, nil
+}
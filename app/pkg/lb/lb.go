@@ -0,0 +1,55 @@
+// Package lb spreads a client's calls over several backends. It registers
+// resolvers for the "static" and "file" target schemes and the
+// least_request balancer, to be used alongside gRPC's own "dns" resolver
+// and round_robin balancer.
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+func init() {
+	resolver.Register(StaticResolver{})
+	resolver.Register(FileResolver{Interval: DefaultFileInterval})
+	balancer.Register(leastRequestBuilder{})
+}
+
+// Policies are the balancers a client can pick.
+var Policies = []string{"pick_first", "round_robin", LeastRequest}
+
+// Target turns a comma-separated list of addresses into a static target
+// and returns anything else, a single address or a target with its own
+// scheme such as "dns:///greeter:50051", as is.
+func Target(addrs string) string {
+	if strings.Contains(addrs, ",") && !strings.Contains(addrs, "://") {
+		return "static:///" + addrs
+	}
+	return addrs
+}
+
+// WithPolicy returns serviceConfig, a service config in JSON, with its
+// load balancing policy set to policy.
+func WithPolicy(serviceConfig, policy string) (string, error) {
+	known := false
+	for _, p := range Policies {
+		known = known || p == policy
+	}
+	if !known {
+		return "", fmt.Errorf("unknown load balancing policy %q", policy)
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(serviceConfig), &config); err != nil {
+		return "", fmt.Errorf("service config: %w", err)
+	}
+	config["loadBalancingConfig"] = []interface{}{map[string]interface{}{policy: map[string]interface{}{}}}
+	// drop the older field so that the config names a single policy
+	delete(config, "loadBalancingPolicy")
+	b, err := json.Marshal(config)
+	return string(b), err
+}
@@ -0,0 +1,261 @@
+package lb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// greeter is one backend, answering with its address.
+type greeter struct {
+	grpctest.Greeter
+	addr   string
+	delay  time.Duration
+	calls  int64
+	health *health.Server
+	srv    *grpc.Server
+}
+
+func (g *greeter) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	atomic.AddInt64(&g.calls, 1)
+	time.Sleep(g.delay)
+	return &hellopb.HelloResponse{Message: g.addr}, nil
+}
+
+func startBackends(t *testing.T, n int) []*greeter {
+	t.Helper()
+	backends := make([]*greeter, n)
+	for i := range backends {
+		g := &greeter{health: health.NewServer()}
+		g.addr = grpctest.Listen(t, g, grpctest.Register(func(s *grpc.Server) {
+			healthpb.RegisterHealthServer(s, g.health)
+			g.srv = s
+		}))
+		backends[i] = g
+	}
+	return backends
+}
+
+func addrs(backends []*greeter) []string {
+	var s []string
+	for _, g := range backends {
+		s = append(s, g.addr)
+	}
+	return s
+}
+
+const healthChecked = `{"healthCheckConfig": {"serviceName": ""}}`
+
+func dial(t *testing.T, target, policy string, opts ...grpc.DialOption) hellopb.GreetingServiceClient {
+	t.Helper()
+	config, err := WithPolicy(healthChecked, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultServiceConfig(config))
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return hellopb.NewGreetingServiceClient(conn)
+}
+
+// hits makes n calls and counts them by the backend that answered, or by
+// the status code of the calls that failed.
+func hits(client hellopb.GreetingServiceClient, n int) map[string]int {
+	got := map[string]int{}
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		res, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "gopher"})
+		cancel()
+		if err != nil {
+			got[status.Code(err).String()]++
+			continue
+		}
+		got[res.GetMessage()]++
+	}
+	return got
+}
+
+// eventually polls until the calls hit exactly the backends in want. Calls
+// sent just as a backend goes away may fail, as gRPC does not resend them.
+func eventually(t *testing.T, client hellopb.GreetingServiceClient, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := hits(client, 5*len(want))
+		ok := len(got) == len(want)
+		for _, addr := range want {
+			ok = ok && got[addr] > 0
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("calls went to %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	backends := startBackends(t, 3)
+	client := dial(t, Target(strings.Join(addrs(backends), ",")), "round_robin")
+	eventually(t, client, addrs(backends))
+
+	got := hits(client, 30)
+	for _, g := range backends {
+		if got[g.addr] != 10 {
+			t.Errorf("calls = %v, want 10 on each backend", got)
+			break
+		}
+	}
+}
+
+func TestLeastRequest(t *testing.T) {
+	backends := startBackends(t, 3)
+	backends[0].delay = 50 * time.Millisecond
+	client := dial(t, Target(strings.Join(addrs(backends), ",")), LeastRequest)
+	eventually(t, client, addrs(backends))
+	for _, g := range backends {
+		atomic.StoreInt64(&g.calls, 0)
+	}
+
+	var wg sync.WaitGroup
+	stop := time.Now().Add(300 * time.Millisecond)
+	for w := 0; w < 6; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	slow := atomic.LoadInt64(&backends[0].calls)
+	for _, g := range backends[1:] {
+		if fast := atomic.LoadInt64(&g.calls); slow*4 > fast {
+			t.Errorf("slow backend got %d calls, a fast one %d; want the slow one avoided", slow, fast)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	for _, policy := range []string{"round_robin", LeastRequest} {
+		t.Run(policy, func(t *testing.T) {
+			backends := startBackends(t, 3)
+			client := dial(t, Target(strings.Join(addrs(backends), ",")), policy)
+			eventually(t, client, addrs(backends))
+
+			// a backend going away
+			backends[2].srv.Stop()
+			eventually(t, client, addrs(backends[:2]))
+
+			// a backend draining, as the server does on shutdown
+			backends[1].health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			eventually(t, client, addrs(backends[:1]))
+
+			backends[1].health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+			eventually(t, client, addrs(backends[:2]))
+		})
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	backends := startBackends(t, 3)
+	file := filepath.Join(t.TempDir(), "backends")
+	write := func(addrs ...string) {
+		t.Helper()
+		// write and rename, so the resolver never reads half a file
+		tmp := file + ".tmp"
+		if err := os.WriteFile(tmp, []byte("# backends\n"+strings.Join(addrs, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(backends[0].addr)
+	client := dial(t, "file://"+file, "round_robin", grpc.WithResolvers(FileResolver{Interval: 20 * time.Millisecond}))
+	eventually(t, client, addrs(backends[:1]))
+
+	write(backends[1].addr, backends[2].addr)
+	eventually(t, client, addrs(backends[1:]))
+
+	// a file that cannot be read keeps the backends
+	os.Remove(file)
+	time.Sleep(50 * time.Millisecond)
+	eventually(t, client, addrs(backends[1:]))
+}
+
+func TestFileResolverMissingFile(t *testing.T) {
+	_, err := grpc.Dial("file://"+filepath.Join(t.TempDir(), "missing"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithResolvers(FileResolver{}),
+	)
+	if err == nil {
+		t.Error("dial succeeded without a backend file")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := map[string]string{
+		"localhost:50051":      "localhost:50051",
+		"a:1,b:2":              "static:///a:1,b:2",
+		"dns:///greeter:50051": "dns:///greeter:50051",
+		"file:///etc/backends": "file:///etc/backends",
+		"static:///a:1,b:2":    "static:///a:1,b:2",
+		"passthrough:///a:1":   "passthrough:///a:1",
+	}
+	for in, want := range tests {
+		if got := Target(in); got != want {
+			t.Errorf("Target(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	got, err := WithPolicy(`{"loadBalancingPolicy": "pick_first", "methodConfig": []}`, LeastRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(got), &config); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config["loadBalancingPolicy"]; ok {
+		t.Errorf("config %s still has loadBalancingPolicy", got)
+	}
+	if _, ok := config["methodConfig"]; !ok || !strings.Contains(got, `"loadBalancingConfig":[{"least_request":{}}]`) {
+		t.Errorf("config = %s", got)
+	}
+
+	if _, err := WithPolicy(`{}`, "random"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+	if _, err := WithPolicy(`{`, "round_robin"); err == nil {
+		t.Error("invalid config accepted")
+	}
+}
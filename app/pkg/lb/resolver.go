@@ -0,0 +1,152 @@
+package lb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// DefaultFileInterval is how often the registered file resolver rereads
+// its file.
+const DefaultFileInterval = 5 * time.Second
+
+// StaticResolver resolves "static:///host1:port,host2:port" to the listed
+// addresses, which never change.
+type StaticResolver struct{}
+
+func (StaticResolver) Scheme() string { return "static" }
+
+func (StaticResolver) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	list := strings.TrimPrefix(endpoint(target), "/")
+	addrs := parseAddrs(strings.ReplaceAll(list, ",", "\n"))
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("static resolver: no addresses in %q", list)
+	}
+	if err := cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		return nil, err
+	}
+	return nopResolver{}, nil
+}
+
+type nopResolver struct{}
+
+func (nopResolver) ResolveNow(resolver.ResolveNowOptions) {}
+func (nopResolver) Close()                                {}
+
+// FileResolver resolves "file:///path/to/backends", or "file:relative/path",
+// to the addresses listed in the file, one per line, with blank lines and
+// lines starting with "#" ignored. It rereads the file every Interval, and
+// when gRPC asks after a connection failure, so backends can be added and
+// removed by editing the file.
+type FileResolver struct {
+	Interval time.Duration
+}
+
+func (FileResolver) Scheme() string { return "file" }
+
+func (b FileResolver) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &fileResolver{
+		path:  endpoint(target),
+		cc:    cc,
+		now:   make(chan struct{}, 1),
+		close: make(chan struct{}),
+	}
+	// an unreadable file fails the dial, rather than every call later
+	if err := r.update(); err != nil {
+		return nil, err
+	}
+	interval := b.Interval
+	if interval <= 0 {
+		interval = DefaultFileInterval
+	}
+	r.wg.Add(1)
+	go r.watch(interval)
+	return r, nil
+}
+
+type fileResolver struct {
+	path  string
+	cc    resolver.ClientConn
+	last  []byte
+	now   chan struct{}
+	close chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (r *fileResolver) watch(interval time.Duration) {
+	defer r.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.close:
+			return
+		case <-t.C:
+		case <-r.now:
+		}
+		if err := r.update(); err != nil {
+			// the balancer keeps the backends it has
+			r.cc.ReportError(err)
+		}
+	}
+}
+
+// update sends the addresses in the file to gRPC if they have changed.
+func (r *fileResolver) update() error {
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	if r.last != nil && bytes.Equal(b, r.last) {
+		return nil
+	}
+	addrs := parseAddrs(string(b))
+	if len(addrs) == 0 {
+		return fmt.Errorf("file resolver: no addresses in %s", r.path)
+	}
+	if err := r.cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		return err
+	}
+	r.last = b
+	return nil
+}
+
+func (r *fileResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *fileResolver) Close() {
+	close(r.close)
+	r.wg.Wait()
+}
+
+// endpoint is the part of target after the scheme and the empty
+// authority: "/path" for "file:///path" and "path" for "file:path".
+func endpoint(target resolver.Target) string {
+	if target.URL.Opaque != "" {
+		return target.URL.Opaque
+	}
+	return target.URL.Path
+}
+
+func parseAddrs(s string) []resolver.Address {
+	var addrs []resolver.Address
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{Addr: line})
+	}
+	return addrs
+}
@@ -0,0 +1,192 @@
+// Package logging writes one structured zerolog line per gRPC call, with
+// sensitive message fields redacted.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is read from incoming metadata, or generated when
+// missing, and sent back in the response header.
+const RequestIDHeader = "x-request-id"
+
+type Options struct {
+	// Payloads logs the redacted request and response messages. Stream
+	// messages are logged one by one at debug level.
+	Payloads bool
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(RequestIDHeader); len(ids) > 0 && ids[0] != "" && len(ids[0]) <= 128 {
+		return ids[0]
+	}
+	return newRequestID()
+}
+
+// callLogger returns the logger of one call, carrying the fields every line
+// about it should have.
+func callLogger(ctx context.Context, logger zerolog.Logger, method, id string) zerolog.Logger {
+	c := logger.With().Str("request_id", id).Str("method", method)
+	if p, ok := peer.FromContext(ctx); ok {
+		c = c.Str("peer", p.Addr.String())
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		c = c.Str("trace_id", sc.TraceID().String())
+	}
+	return c.Logger()
+}
+
+// level picks the level by who is to blame for the code: the server's
+// problems are errors, the client's are warnings.
+func level(code codes.Code) zerolog.Level {
+	switch code {
+	case codes.OK:
+		return zerolog.InfoLevel
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented, codes.Unavailable, codes.DeadlineExceeded:
+		return zerolog.ErrorLevel
+	}
+	return zerolog.WarnLevel
+}
+
+func finish(logger *zerolog.Logger, start time.Time, err error) *zerolog.Event {
+	code := status.Code(err)
+	e := logger.WithLevel(level(code)).
+		Dur("duration", time.Since(start)).
+		Str("code", code.String())
+	if err != nil {
+		e = e.Str("error", status.Convert(err).Message())
+	}
+	return e
+}
+
+// UnaryServerInterceptor logs every call once it finished. The call's
+// logger is stored in the context for handlers, see zerolog.Ctx.
+func UnaryServerInterceptor(logger zerolog.Logger, opts Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		id := requestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+		l := callLogger(ctx, logger, info.FullMethod, id)
+
+		res, err := handler(l.WithContext(ctx), req)
+
+		e := finish(&l, start, err)
+		if opts.Payloads {
+			e = e.RawJSON("request", payload(req))
+			if err == nil {
+				e = e.RawJSON("response", payload(res))
+			}
+		}
+		e.Msg("finished unary call")
+		return res, err
+	}
+}
+
+// StreamServerInterceptor logs every stream once it finished, with the
+// number of messages exchanged.
+func StreamServerInterceptor(logger zerolog.Logger, opts Options) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		id := requestID(ss.Context())
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+		l := callLogger(ss.Context(), logger, info.FullMethod, id)
+
+		wrapper := &serverStream{ServerStream: ss, ctx: l.WithContext(ss.Context()), logger: &l, payloads: opts.Payloads}
+		err := handler(srv, wrapper)
+
+		finish(&l, start, err).
+			Int("received", wrapper.received).
+			Int("sent", wrapper.sent).
+			Msg("finished streaming call")
+		return err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	logger   *zerolog.Logger
+	payloads bool
+
+	received, sent int
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+		if s.payloads {
+			s.logger.Debug().Int("seq", s.received).RawJSON("message", payload(m)).Msg("received stream message")
+		}
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+		if s.payloads {
+			s.logger.Debug().Int("seq", s.sent).RawJSON("message", payload(m)).Msg("sent stream message")
+		}
+	}
+	return err
+}
+
+// Metadata drops credentials from md so it can be logged.
+func Metadata(md metadata.MD) metadata.MD {
+	md = md.Copy()
+	for _, key := range []string{"authorization", "cookie"} {
+		if len(md.Get(key)) > 0 {
+			md.Set(key, redacted)
+		}
+	}
+	return md
+}
+
+type Config struct {
+	Level string `envconfig:"LEVEL" default:"info"`
+	// Format is "json" or "console".
+	Format   string `envconfig:"FORMAT" default:"json"`
+	Payloads bool   `envconfig:"PAYLOADS"`
+}
+
+// New returns a logger writing to w as cfg says.
+func New(w io.Writer, cfg Config) (zerolog.Logger, error) {
+	level, err := zerolog.ParseLevel(cfg.Level)
+	if err != nil {
+		return zerolog.Logger{}, err
+	}
+	switch cfg.Format {
+	case "", "json":
+	case "console":
+		w = zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339}
+	default:
+		return zerolog.Logger{}, fmt.Errorf("unknown log format %q, want json or console", cfg.Format)
+	}
+	return zerolog.New(w).Level(level).With().Timestamp().Logger(), nil
+}
@@ -0,0 +1,234 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// greeter logs from the handler, to show it gets the call's logger.
+type greeter struct {
+	grpctest.Greeter
+}
+
+func (g greeter) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	res, err := g.Greeter.Hello(ctx, req)
+	if err == nil {
+		zerolog.Ctx(ctx).Info().Msg("from the handler")
+	}
+	return res, err
+}
+
+// syncBuffer is written by the server goroutines and read by the test.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines decodes the JSON log lines written so far.
+func (b *syncBuffer) lines(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func dial(t *testing.T, opts Options) (hellopb.GreetingServiceClient, *syncBuffer) {
+	t.Helper()
+
+	out := &syncBuffer{}
+	logger := zerolog.New(out).Level(zerolog.DebugLevel)
+	client := grpctest.Dial(t, greeter{}, grpctest.ServerOptions(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(logger, opts)),
+		grpc.StreamInterceptor(StreamServerInterceptor(logger, opts)),
+	))
+	return client, out
+}
+
+func TestRedact(t *testing.T) {
+	req := &hellopb.HelloRequest{Name: "alice"}
+	got := Redact(req).(*hellopb.HelloRequest)
+	if got.GetName() != redacted {
+		t.Errorf("name = %q, want %q", got.GetName(), redacted)
+	}
+	if req.GetName() != "alice" {
+		t.Error("Redact changed its argument")
+	}
+
+	res := &hellopb.HelloResponse{Message: "Hello, alice"}
+	if got := Redact(res).(*hellopb.HelloResponse); got.GetMessage() != redacted {
+		t.Errorf("message = %q, want %q", got.GetMessage(), redacted)
+	}
+
+	res = &hellopb.HelloResponse{CreateTime: timestamppb.Now()}
+	if !proto.Equal(Redact(res), res) {
+		t.Error("Redact changed a message without sensitive fields set")
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client, out := dial(t, Options{Payloads: true})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RequestIDHeader, "req-1")
+	var header metadata.MD
+	if _, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "alice"}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get(RequestIDHeader); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("response %s = %q, want req-1", RequestIDHeader, got)
+	}
+	if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{}, grpc.Header(&header)); err == nil {
+		t.Fatal("empty name accepted")
+	}
+	generated := header.Get(RequestIDHeader)
+	if len(generated) != 1 || generated[0] == "" {
+		t.Errorf("no request ID generated: %q", generated)
+	}
+
+	lines := out.lines(t)
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3: %v", len(lines), lines)
+	}
+	if lines[0]["message"] != "from the handler" || lines[0]["request_id"] != "req-1" {
+		t.Errorf("handler line = %v, want the call's fields", lines[0])
+	}
+
+	ok := lines[1]
+	for k, want := range map[string]interface{}{
+		"level":      "info",
+		"request_id": "req-1",
+		"method":     "/myapp.GreetingService/Hello",
+		"code":       "OK",
+		"peer":       "bufconn",
+	} {
+		if ok[k] != want {
+			t.Errorf("%s = %v, want %v", k, ok[k], want)
+		}
+	}
+	if _, found := ok["duration"]; !found {
+		t.Error("no duration logged")
+	}
+	if req := ok["request"].(map[string]interface{}); req["name"] != redacted {
+		t.Errorf("request = %v, want the name redacted", req)
+	}
+	if res := ok["response"].(map[string]interface{}); res["message"] != redacted {
+		t.Errorf("response = %v, want the message redacted", res)
+	}
+	if strings.Contains(out.buf.String(), "alice") {
+		t.Errorf("name logged: %v", ok)
+	}
+
+	failed := lines[2]
+	if failed["level"] != "warn" || failed["code"] != "InvalidArgument" || failed["request_id"] != generated[0] {
+		t.Errorf("failed call line = %v", failed)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client, out := dial(t, Options{})
+
+	stream, err := client.HelloBiStreams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if err := stream.Send(&hellopb.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after CloseSend: %v", err)
+	}
+
+	lines := out.lines(t)
+	if len(lines) != 1 {
+		t.Fatalf("got %d log lines, want 1 without payloads: %v", len(lines), lines)
+	}
+	if lines[0]["received"] != 2.0 || lines[0]["sent"] != 2.0 || lines[0]["code"] != "OK" {
+		t.Errorf("stream line = %v", lines[0])
+	}
+	if strings.Contains(out.buf.String(), "alice") {
+		t.Error("payload logged although disabled")
+	}
+}
+
+func TestStreamPayloadsRedacted(t *testing.T) {
+	client, out := dial(t, Options{Payloads: true})
+
+	stream, err := client.HelloBiStreams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&hellopb.HelloRequest{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after CloseSend: %v", err)
+	}
+
+	lines := out.lines(t)
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want received, sent and finished: %v", len(lines), lines)
+	}
+	log := out.buf.String()
+	if !strings.Contains(log, `{"name":"[REDACTED]"}`) || !strings.Contains(log, `"message":"[REDACTED]"`) {
+		t.Errorf("payloads not logged redacted:\n%s", log)
+	}
+	if strings.Contains(log, "alice") {
+		t.Errorf("name logged:\n%s", log)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	md := metadata.Pairs("authorization", "Bearer secret", "type", "unary")
+	got := Metadata(md)
+	if v := got.Get("authorization"); len(v) != 1 || v[0] != redacted {
+		t.Errorf("authorization = %q", v)
+	}
+	if v := got.Get("type"); len(v) != 1 || v[0] != "unary" {
+		t.Errorf("type = %q", v)
+	}
+	if md.Get("authorization")[0] != "Bearer secret" {
+		t.Error("Metadata changed its argument")
+	}
+}
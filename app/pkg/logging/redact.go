@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	hellopb "mygrpc/pkg/grpc"
+)
+
+const redacted = "[REDACTED]"
+
+// Redact returns a copy of m with every field marked (myapp.sensitive)
+// masked, in nested messages too. Strings are replaced by "[REDACTED]" so
+// the log still shows the field was set; other kinds are cleared.
+func Redact(m proto.Message) proto.Message {
+	m = proto.Clone(m)
+	redact(m.ProtoReflect())
+	return m
+}
+
+func sensitive(fd protoreflect.FieldDescriptor) bool {
+	opts, ok := fd.Options().(*descriptorpb.FieldOptions)
+	return ok && proto.GetExtension(opts, hellopb.E_Sensitive).(bool)
+}
+
+func redact(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case sensitive(fd):
+			mask(m, fd)
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				redact(v.Message())
+				return true
+			})
+		case fd.IsList() && fd.Message() != nil:
+			for i := 0; i < v.List().Len(); i++ {
+				redact(v.List().Get(i).Message())
+			}
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			redact(v.Message())
+		}
+		return true
+	})
+}
+
+func mask(m protoreflect.Message, fd protoreflect.FieldDescriptor) {
+	if fd.Kind() != protoreflect.StringKind || fd.IsMap() {
+		m.Clear(fd)
+		return
+	}
+	if fd.IsList() {
+		list := m.Mutable(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			list.Set(i, protoreflect.ValueOfString(redacted))
+		}
+		return
+	}
+	m.Set(fd, protoreflect.ValueOfString(redacted))
+}
+
+// payload renders a redacted message for the log.
+func payload(v interface{}) []byte {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return []byte(`null`)
+	}
+	b, err := protojson.Marshal(Redact(m))
+	if err != nil {
+		return []byte(`null`)
+	}
+	return b
+}
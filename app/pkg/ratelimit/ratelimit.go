@@ -0,0 +1,318 @@
+// Package ratelimit protects a server from callers that send too much: a
+// token bucket per method and caller bounds the call rate, and a cap on
+// open streams per caller bounds long-lived streams. Rejected calls fail
+// with ResourceExhausted and say when to retry, both in a RetryInfo detail
+// and in the grpc-retry-pushback-ms trailer that gRPC retry policies obey.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"mygrpc/internal/methods"
+	"mygrpc/pkg/auth"
+)
+
+// PushbackTrailer is the trailer gRPC clients read the retry delay from,
+// in milliseconds.
+const PushbackTrailer = "grpc-retry-pushback-ms"
+
+// StreamRetryDelay is suggested to callers turned away for having too
+// many streams open, as there is no telling when one will close.
+const StreamRetryDelay = time.Second
+
+// sweepInterval is how often buckets that have refilled are dropped, so
+// that callers who went away do not hold memory.
+const sweepInterval = time.Minute
+
+// Limit is a token bucket refilled at Rate tokens per second and holding
+// at most Burst. A zero Rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// Limits overrides the limit of some methods, by full method name such as
+// "/myapp.GreetingService/Hello" or by method name alone. As a flag or
+// environment variable it reads "Hello:5/10,HelloBiStreams:1", rate then
+// optional burst.
+type Limits map[string]Limit
+
+func (ls Limits) String() string {
+	pairs := make([]string, 0, len(ls))
+	for method, l := range ls {
+		pair := method + ":" + strconv.FormatFloat(l.Rate, 'g', -1, 64)
+		if l.Burst > 0 {
+			pair += "/" + strconv.Itoa(l.Burst)
+		}
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (ls *Limits) Set(s string) error {
+	limits := Limits{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		method, limit, ok := strings.Cut(pair, ":")
+		if !ok || method == "" {
+			return fmt.Errorf("rate limit %q: want method:rate[/burst]", pair)
+		}
+		rate, burst, hasBurst := strings.Cut(limit, "/")
+		var l Limit
+		var err error
+		if l.Rate, err = strconv.ParseFloat(rate, 64); err != nil || l.Rate < 0 {
+			return fmt.Errorf("rate limit %q: bad rate %q", pair, rate)
+		}
+		if hasBurst {
+			if l.Burst, err = strconv.Atoi(burst); err != nil || l.Burst < 1 {
+				return fmt.Errorf("rate limit %q: bad burst %q", pair, burst)
+			}
+		}
+		limits[method] = l
+	}
+	*ls = limits
+	return nil
+}
+
+// Config sets the limits, which apply to each caller separately.
+type Config struct {
+	// Rate and Burst limit the calls to each method; Methods overrides
+	// them per method.
+	Rate    float64 `envconfig:"RATE"`
+	Burst   int     `envconfig:"BURST"`
+	Methods Limits  `envconfig:"METHODS"`
+	// MaxStreams caps the streaming calls a caller has open at once; zero
+	// is unlimited.
+	MaxStreams int `envconfig:"MAX_STREAMS"`
+	// Key tells callers apart: "peer" by IP address, "caller" by
+	// authenticated subject, or else by the value of that metadata key,
+	// which only a trusted proxy in front should set. Callers without one
+	// fall back to their IP address.
+	Key string `envconfig:"KEY" default:"peer"`
+}
+
+// Enabled reports whether c limits anything.
+func (c Config) Enabled() bool {
+	if c.Rate > 0 || c.MaxStreams > 0 {
+		return true
+	}
+	for _, l := range c.Methods {
+		if l.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Limiter holds the buckets and open stream counts of every caller.
+type Limiter struct {
+	cfg    Config
+	exempt []string
+	// Now is the clock, replaceable in tests.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	streams   map[string]int
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	method, caller string
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter for cfg. Calls to methods matching exempt, see
+// methods.Match, are never limited.
+func New(cfg Config, exempt ...string) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		exempt:  exempt,
+		Now:     time.Now,
+		buckets: map[bucketKey]*bucket{},
+		streams: map[string]int{},
+	}
+}
+
+func (l *Limiter) limit(method string) Limit {
+	if lim, ok := l.cfg.Methods[method]; ok {
+		return lim
+	}
+	if lim, ok := l.cfg.Methods[method[strings.LastIndex(method, "/")+1:]]; ok {
+		return lim
+	}
+	return Limit{Rate: l.cfg.Rate, Burst: l.cfg.Burst}
+}
+
+// caller identifies who makes the call in ctx, per Config.Key.
+func (l *Limiter) caller(ctx context.Context) string {
+	switch l.cfg.Key {
+	case "", "peer":
+	case "caller":
+		if id, ok := auth.FromContext(ctx); ok {
+			return "caller:" + id.Subject
+		}
+	default:
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(l.cfg.Key); len(v) > 0 && v[0] != "" {
+			return l.cfg.Key + ":" + v[0]
+		}
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "peer:unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	return "peer:" + host
+}
+
+// take spends a token of the caller's bucket for method. When it is empty
+// it returns how long until the next token.
+func (l *Limiter) take(method, caller string) (time.Duration, bool) {
+	lim := l.limit(method)
+	if lim.Rate <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.Now()
+	l.sweep(now)
+
+	k := bucketKey{method, caller}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{limit: lim, tokens: lim.burst(), last: now}
+		l.buckets[k] = b
+	}
+	b.tokens = math.Min(lim.burst(), b.tokens+now.Sub(b.last).Seconds()*lim.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second)), false
+}
+
+// sweep drops the buckets that have refilled since their last call, as a
+// new bucket starts full anyway.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= b.limit.burst() {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// openStream counts a stream of caller, unless it has MaxStreams open
+// already. The returned func closes it.
+func (l *Limiter) openStream(caller string) (func(), bool) {
+	if l.cfg.MaxStreams <= 0 {
+		return func() {}, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams[caller] >= l.cfg.MaxStreams {
+		return nil, false
+	}
+	l.streams[caller]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.streams[caller]--; l.streams[caller] <= 0 {
+			delete(l.streams, caller)
+		}
+	}, true
+}
+
+// rejection is the error of a limited call, after setting the pushback
+// trailer with setTrailer.
+func rejection(setTrailer func(metadata.MD) error, delay time.Duration, subject, desc string) error {
+	ms := int64(math.Ceil(float64(delay) / float64(time.Millisecond)))
+	setTrailer(metadata.Pairs(PushbackTrailer, strconv.FormatInt(ms, 10)))
+
+	stat := status.New(codes.ResourceExhausted, fmt.Sprintf("%s: %s, retry in %s", subject, desc, time.Duration(ms)*time.Millisecond))
+	stat, _ = stat.WithDetails(
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{
+				{Subject: subject, Description: desc},
+			},
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)},
+	)
+	return stat.Err()
+}
+
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if methods.Match(info.FullMethod, l.exempt) {
+			return handler(ctx, req)
+		}
+		caller := l.caller(ctx)
+		if delay, ok := l.take(info.FullMethod, caller); !ok {
+			setTrailer := func(md metadata.MD) error { return grpc.SetTrailer(ctx, md) }
+			return nil, rejection(setTrailer, delay, caller, "rate limit of "+info.FullMethod+" exceeded")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if methods.Match(info.FullMethod, l.exempt) {
+			return handler(srv, ss)
+		}
+		setTrailer := func(md metadata.MD) error {
+			ss.SetTrailer(md)
+			return nil
+		}
+		caller := l.caller(ss.Context())
+		if delay, ok := l.take(info.FullMethod, caller); !ok {
+			return rejection(setTrailer, delay, caller, "rate limit of "+info.FullMethod+" exceeded")
+		}
+		closeStream, ok := l.openStream(caller)
+		if !ok {
+			return rejection(setTrailer, StreamRetryDelay, caller, fmt.Sprintf("more than %d streams open", l.cfg.MaxStreams))
+		}
+		defer closeStream()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,290 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/grpctest"
+	"mygrpc/pkg/auth"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+// clock is a settable time for Limiter.Now.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func dial(t *testing.T, l *Limiter) (hellopb.GreetingServiceClient, *clock) {
+	t.Helper()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	l.Now = c.Now
+
+	client := grpctest.Dial(t, grpctest.Greeter{}, grpctest.ServerOptions(
+		grpc.UnaryInterceptor(l.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(l.StreamServerInterceptor()),
+	))
+	return client, c
+}
+
+func hello(client hellopb.GreetingServiceClient, clientID string) (metadata.MD, error) {
+	ctx := context.Background()
+	if clientID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-client-id", clientID)
+	}
+	var trailer metadata.MD
+	_, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "gopher"}, grpc.Trailer(&trailer))
+	return trailer, err
+}
+
+func retryDelay(err error) time.Duration {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	return -1
+}
+
+func TestRateLimit(t *testing.T) {
+	client, clock := dial(t, New(Config{Rate: 2, Burst: 3}))
+
+	for i := 0; i < 3; i++ {
+		if _, err := hello(client, ""); err != nil {
+			t.Fatalf("call %d within the burst: %v", i, err)
+		}
+	}
+
+	trailer, err := hello(client, "")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("err = %v, want ResourceExhausted", err)
+	}
+	if d := retryDelay(err); d != 500*time.Millisecond {
+		t.Errorf("RetryInfo delay = %s, want 500ms", d)
+	}
+	if got := trailer.Get(PushbackTrailer); len(got) != 1 || got[0] != "500" {
+		t.Errorf("%s = %q, want 500", PushbackTrailer, got)
+	}
+
+	clock.Advance(250 * time.Millisecond)
+	if _, err := hello(client, ""); retryDelay(err) != 250*time.Millisecond {
+		t.Errorf("halfway: %v, want a 250ms retry delay", err)
+	}
+	clock.Advance(250 * time.Millisecond)
+	if _, err := hello(client, ""); err != nil {
+		t.Errorf("after the refill: %v", err)
+	}
+}
+
+func TestRateLimitPerCaller(t *testing.T) {
+	client, _ := dial(t, New(Config{Rate: 1, Burst: 1, Key: "x-client-id"}))
+
+	if _, err := hello(client, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hello(client, "alice"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("alice again: %v, want ResourceExhausted", err)
+	}
+	if _, err := hello(client, "bob"); err != nil {
+		t.Errorf("bob limited by alice's calls: %v", err)
+	}
+	// without the key, the peer address is the caller
+	if _, err := hello(client, ""); err != nil {
+		t.Errorf("anonymous: %v", err)
+	}
+	if _, err := hello(client, ""); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("anonymous again: %v, want ResourceExhausted", err)
+	}
+}
+
+func TestRateLimitPerMethod(t *testing.T) {
+	cfg := Config{Rate: 1, Burst: 1, Methods: Limits{"Hello": {Rate: 0}}}
+	client, _ := dial(t, New(cfg))
+	for i := 0; i < 5; i++ {
+		if _, err := hello(client, ""); err != nil {
+			t.Fatalf("unlimited Hello, call %d: %v", i, err)
+		}
+	}
+
+	cfg = Config{Methods: Limits{"/myapp.GreetingService/Hello": {Rate: 1, Burst: 1}}}
+	client, _ = dial(t, New(cfg))
+	hello(client, "")
+	if _, err := hello(client, ""); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("limited Hello: %v, want ResourceExhausted", err)
+	}
+	// other methods stay unlimited
+	for i := 0; i < 3; i++ {
+		stream, err := client.HelloBiStreams(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.CloseSend()
+		if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+			t.Errorf("stream %d: %v", i, err)
+		}
+	}
+}
+
+func TestExempt(t *testing.T) {
+	client, _ := dial(t, New(Config{Rate: 1, Burst: 1}, "/myapp.GreetingService/"))
+	for i := 0; i < 3; i++ {
+		if _, err := hello(client, ""); err != nil {
+			t.Fatalf("exempt call %d: %v", i, err)
+		}
+	}
+}
+
+func TestMaxStreams(t *testing.T) {
+	client, _ := dial(t, New(Config{MaxStreams: 2}))
+
+	// open reports the error of a new stream once it has started
+	open := func() (hellopb.GreetingService_HelloBiStreamsClient, error) {
+		stream, err := client.HelloBiStreams(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&hellopb.HelloRequest{Name: "gopher"}); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		_, err = stream.Recv()
+		return stream, err
+	}
+
+	first, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := open()
+	if status.Code(err) != codes.ResourceExhausted || retryDelay(err) != StreamRetryDelay {
+		t.Fatalf("third stream: %v, want ResourceExhausted with a retry delay", err)
+	}
+	if got := stream.Trailer().Get(PushbackTrailer); len(got) != 1 || got[0] != "1000" {
+		t.Errorf("%s = %q, want 1000", PushbackTrailer, got)
+	}
+
+	first.CloseSend()
+	if _, err := first.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+	// the server counts the stream closed once its handler has returned
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := open()
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.ResourceExhausted || time.Now().After(deadline) {
+			t.Fatalf("after closing a stream: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCaller(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321}})
+	withID := auth.NewContext(ctx, auth.Identity{Subject: "alice", Method: "api-key"})
+	withMD := metadata.NewIncomingContext(ctx, metadata.Pairs("x-client-id", "bob"))
+
+	tests := []struct {
+		key  string
+		ctx  context.Context
+		want string
+	}{
+		{"peer", withID, "peer:10.0.0.1"},
+		{"", ctx, "peer:10.0.0.1"},
+		{"caller", withID, "caller:alice"},
+		{"caller", ctx, "peer:10.0.0.1"},
+		{"x-client-id", withMD, "x-client-id:bob"},
+		{"x-client-id", ctx, "peer:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := New(Config{Key: tt.key}).caller(tt.ctx); got != tt.want {
+			t.Errorf("key %q: caller = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(Config{Rate: 1, Burst: 2})
+	c := &clock{now: time.Unix(1700000000, 0)}
+	l.Now = c.Now
+
+	l.take("/m", "idle")
+	c.Advance(sweepInterval)
+	l.take("/m", "busy")
+	l.take("/m", "busy")
+	c.Advance(sweepInterval / 2)
+
+	if _, ok := l.buckets[bucketKey{"/m", "idle"}]; ok {
+		t.Error("refilled bucket kept")
+	}
+	if _, ok := l.buckets[bucketKey{"/m", "busy"}]; !ok {
+		t.Error("bucket in use dropped")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var ls Limits
+	if err := ls.Set("Hello:5/10, /myapp.GreetingService/HelloBiStreams:0.5"); err != nil {
+		t.Fatal(err)
+	}
+	want := Limits{"Hello": {Rate: 5, Burst: 10}, "/myapp.GreetingService/HelloBiStreams": {Rate: 0.5}}
+	if len(ls) != len(want) || ls["Hello"] != want["Hello"] || ls["/myapp.GreetingService/HelloBiStreams"] != want["/myapp.GreetingService/HelloBiStreams"] {
+		t.Errorf("Set = %v, want %v", ls, want)
+	}
+	if got := ls.String(); got != "/myapp.GreetingService/HelloBiStreams:0.5,Hello:5/10" {
+		t.Errorf("String = %q", got)
+	}
+
+	for _, bad := range []string{"Hello", "Hello:fast", "Hello:-1", "Hello:1/0", ":1"} {
+		if err := new(Limits).Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want bool
+	}{
+		{Config{}, false},
+		{Config{Key: "caller"}, false},
+		{Config{Rate: 1}, true},
+		{Config{MaxStreams: 1}, true},
+		{Config{Methods: Limits{"Hello": {Rate: 1}}}, true},
+		{Config{Methods: Limits{"Hello": {Rate: 0}}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Enabled(); got != tt.want {
+			t.Errorf("%+v: Enabled = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package retry retries failed unary gRPC calls on the client, with
+// exponential backoff, and reports how many attempts a call took.
+//
+// gRPC can also retry on its own from the retryPolicy of a service
+// config, but it does not tell the caller how many attempts were made.
+// Methods retried by this package should not also have a retryPolicy, or
+// each of these attempts is retried again by gRPC.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mygrpc/internal/methods"
+)
+
+// AttemptsKey is the trailer key under which the interceptor reports the
+// number of attempts a call took, for callers that pass grpc.Trailer.
+const AttemptsKey = "retry-attempts"
+
+// Policy says when and how often a call is retried. It follows the
+// retryPolicy of a gRPC service config.
+type Policy struct {
+	// MaxAttempts counts the first attempt, so 1 disables retrying.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// MaxRetryDelay caps the delay a RetryInfo detail asks for, so one bad
+	// or hostile reply cannot stall a call without a deadline for hours.
+	// Zero means DefaultMaxRetryDelay.
+	MaxRetryDelay time.Duration
+	// Codes are the retryable status codes.
+	Codes []codes.Code
+	// OnRetry, if set, is called before each retry with the attempt that
+	// failed and the delay before the next one.
+	OnRetry func(method string, attempt int, err error, delay time.Duration)
+}
+
+const DefaultMaxRetryDelay = 30 * time.Second
+
+// pushbackTrailer is where servers, such as pkg/ratelimit, may say how many
+// milliseconds to wait before retrying.
+const pushbackTrailer = "grpc-retry-pushback-ms"
+
+// DefaultPolicy retries Unavailable and ResourceExhausted calls up to
+// three attempts in total. ResourceExhausted is only retried when the
+// server says when to come back; see Policy.retryable.
+var DefaultPolicy = Policy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Codes:          []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+}
+
+// retryable reports whether a call that failed with err and trailer may
+// be tried again. ResourceExhausted is retried only with a RetryInfo detail
+// or a pushback trailer: without one the quota is not going to free up,
+// e.g. for a name that is too long, and every attempt fails alike.
+func (p Policy) retryable(err error, trailer metadata.MD) bool {
+	code := status.Code(err)
+	for _, c := range p.Codes {
+		if c == code {
+			if code == codes.ResourceExhausted {
+				_, ok := serverDelay(err, trailer)
+				return ok
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// serverDelay returns the delay the server asked for, in a RetryInfo
+// detail of err or else in the pushback trailer.
+func serverDelay(err error, trailer metadata.MD) (time.Duration, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok && info.GetRetryDelay().IsValid() {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	if v := trailer.Get(pushbackTrailer); len(v) > 0 {
+		if ms, err := strconv.ParseInt(v[0], 10, 64); err == nil && ms >= 0 {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+	}
+	return 0, false
+}
+
+// backoff returns the delay before retry n, counting from 1: a random
+// duration up to InitialBackoff*Multiplier^(n-1), capped at MaxBackoff. A
+// delay asked for by the server in err or trailer overrides it, as the
+// server knows best when to come back, up to MaxRetryDelay.
+func (p Policy) backoff(n int, err error, trailer metadata.MD) time.Duration {
+	if delay, ok := serverDelay(err, trailer); ok {
+		limit := p.MaxRetryDelay
+		if limit <= 0 {
+			limit = DefaultMaxRetryDelay
+		}
+		if delay < limit {
+			return delay
+		}
+		return limit
+	}
+	ceiling := float64(p.InitialBackoff)
+	for i := 1; i < n; i++ {
+		ceiling *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && ceiling > float64(p.MaxBackoff) {
+		ceiling = float64(p.MaxBackoff)
+	}
+	if ceiling < 1 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// UnaryClientInterceptor retries calls to methods matching idempotent, see
+// methods.Match, while they fail with one of p.Codes and the context
+// allows. Each attempt gets the method timeout of the service config anew;
+// the context deadline bounds them all. Other methods are called once.
+func UnaryClientInterceptor(p Policy, idempotent ...string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if p.MaxAttempts <= 1 || !methods.Match(method, idempotent) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var err error
+		attempt := 1
+		for ; ; attempt++ {
+			var trailer metadata.MD
+			last := err
+			err = invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+			if last != nil && ctx.Err() != nil && status.Code(err) == codes.DeadlineExceeded {
+				// the deadline cut a retry short; why the call failed is
+				// still the error that made us retry
+				err = last
+				break
+			}
+			if err == nil || attempt == p.MaxAttempts || !p.retryable(err, trailer) {
+				break
+			}
+
+			delay := p.backoff(attempt, err, trailer)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				break // the next attempt would only fail with DeadlineExceeded
+			}
+			if p.OnRetry != nil {
+				p.OnRetry(method, attempt, err, delay)
+			}
+			if !sleep(ctx, delay) {
+				break
+			}
+		}
+		reportAttempts(opts, attempt)
+		return err
+	}
+}
+
+// sleep waits for d and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// reportAttempts adds the attempt count to the trailer requested with
+// grpc.Trailer, if any. gRPC fills that trailer anew on each attempt, so it
+// holds the trailer of the last one.
+func reportAttempts(opts []grpc.CallOption, attempts int) {
+	for _, o := range opts {
+		t, ok := o.(grpc.TrailerCallOption)
+		if !ok {
+			continue
+		}
+		if *t.TrailerAddr == nil {
+			*t.TrailerAddr = metadata.MD{}
+		}
+		t.TrailerAddr.Set(AttemptsKey, strconv.Itoa(attempts))
+	}
+}
@@ -0,0 +1,198 @@
+package retry
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+const helloMethod = "/myapp.GreetingService/Hello"
+
+// flaky fails the first failures calls with err, adding trailer to them.
+type flaky struct {
+	grpctest.Greeter
+	failures int32
+	err      error
+	trailer  metadata.MD
+	calls    int32
+}
+
+func (s *flaky) Hello(ctx context.Context, req *hellopb.HelloRequest) (*hellopb.HelloResponse, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	grpc.SetTrailer(ctx, metadata.Pairs("call", string(rune('0'+n))))
+	if n <= s.failures {
+		grpc.SetTrailer(ctx, s.trailer)
+		return nil, s.err
+	}
+	return &hellopb.HelloResponse{Message: "Hello, " + req.GetName() + "!"}, nil
+}
+
+var fastPolicy = Policy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+	Multiplier:     2,
+	Codes:          []codes.Code{codes.Unavailable},
+}
+
+func TestRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "try again")
+	tests := []struct {
+		name       string
+		failures   int32
+		err        error
+		idempotent []string
+		code       codes.Code
+		attempts   string
+	}{
+		{"success", 0, nil, []string{helloMethod}, codes.OK, "1"},
+		{"recovers", 2, unavailable, []string{helloMethod}, codes.OK, "3"},
+		{"service prefix", 1, unavailable, []string{"/myapp.GreetingService/"}, codes.OK, "2"},
+		{"gives up", 3, unavailable, []string{helloMethod}, codes.Unavailable, "3"},
+		{"not retryable", 1, status.Error(codes.InvalidArgument, "bad"), []string{helloMethod}, codes.InvalidArgument, "1"},
+		{"not idempotent", 1, unavailable, nil, codes.Unavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &flaky{failures: tt.failures, err: tt.err}
+			var retries int
+			p := fastPolicy
+			p.OnRetry = func(method string, attempt int, err error, delay time.Duration) {
+				retries++
+				if method != helloMethod || attempt != retries || status.Code(err) != status.Code(tt.err) {
+					t.Errorf("OnRetry(%s, %d, %v, %s)", method, attempt, err, delay)
+				}
+			}
+			client := grpctest.Dial(t, srv, grpctest.DialOptions(grpc.WithUnaryInterceptor(UnaryClientInterceptor(p, tt.idempotent...))))
+
+			var trailer metadata.MD
+			_, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}, grpc.Trailer(&trailer))
+			if status.Code(err) != tt.code {
+				t.Errorf("err = %v, want %v", err, tt.code)
+			}
+			if got := trailer.Get(AttemptsKey); tt.attempts == "" && len(got) != 0 || tt.attempts != "" && (len(got) != 1 || got[0] != tt.attempts) {
+				t.Errorf("%s = %q, want %q", AttemptsKey, got, tt.attempts)
+			}
+			if got := trailer.Get("call"); len(got) != 1 || got[0] != string(rune('0'+srv.calls)) {
+				t.Errorf("trailer from call %q, want the last of %d", got, srv.calls)
+			}
+			if retries+1 != int(srv.calls) {
+				t.Errorf("%d retries reported for %d calls", retries, srv.calls)
+			}
+		})
+	}
+}
+
+func TestRetryInfo(t *testing.T) {
+	stat, _ := status.New(codes.Unavailable, "busy").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(100 * time.Millisecond),
+	})
+	srv := &flaky{failures: 1, err: stat.Err()}
+	client := grpctest.Dial(t, srv, grpctest.DialOptions(grpc.WithUnaryInterceptor(UnaryClientInterceptor(fastPolicy, helloMethod))))
+
+	start := time.Now()
+	if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("retried after %s, before the server's retry delay", d)
+	}
+}
+
+func TestRetryResourceExhausted(t *testing.T) {
+	quota, _ := status.New(codes.ResourceExhausted, "name too long").WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{Subject: "name", Description: "too long"}},
+	})
+	limited, _ := quota.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Millisecond)})
+	tests := []struct {
+		name    string
+		err     error
+		trailer metadata.MD
+		calls   int32
+	}{
+		{"quota failure only", quota.Err(), nil, 1},
+		{"retry info", limited.Err(), nil, 2},
+		{"pushback trailer", quota.Err(), metadata.Pairs(pushbackTrailer, "1"), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &flaky{failures: 1, err: tt.err, trailer: tt.trailer}
+			p := fastPolicy
+			p.Codes = DefaultPolicy.Codes
+			client := grpctest.Dial(t, srv, grpctest.DialOptions(grpc.WithUnaryInterceptor(UnaryClientInterceptor(p, helloMethod))))
+
+			client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"})
+			if srv.calls != tt.calls {
+				t.Errorf("%d attempts, want %d", srv.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	srv := &flaky{failures: 1000, err: status.Error(codes.Unavailable, "down")}
+	p := fastPolicy
+	p.MaxAttempts = 1000
+	p.InitialBackoff, p.MaxBackoff = 20*time.Millisecond, 20*time.Millisecond
+	client := grpctest.Dial(t, srv, grpctest.DialOptions(grpc.WithUnaryInterceptor(UnaryClientInterceptor(p, helloMethod))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "gopher"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("err = %v, want the last Unavailable", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("kept retrying for %s past the deadline", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Multiplier: 2}
+	for n, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 10: 300 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(n, nil, nil); d < 0 || d >= ceiling {
+				t.Fatalf("backoff(%d) = %s, want below %s", n, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestBackoffRetryInfoCapped(t *testing.T) {
+	stat, _ := status.New(codes.Unavailable, "busy").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(24 * time.Hour),
+	})
+	p := Policy{MaxRetryDelay: 50 * time.Millisecond}
+	if d := p.backoff(1, stat.Err(), nil); d != 50*time.Millisecond {
+		t.Errorf("backoff = %s, want MaxRetryDelay", d)
+	}
+	p.MaxRetryDelay = 0
+	if d := p.backoff(1, stat.Err(), nil); d != DefaultMaxRetryDelay {
+		t.Errorf("backoff = %s, want DefaultMaxRetryDelay", d)
+	}
+
+	// no deadline, so only the cap keeps the call from sleeping a day
+	p = fastPolicy
+	p.MaxRetryDelay = 50 * time.Millisecond
+	srv := &flaky{failures: 1, err: stat.Err()}
+	client := grpctest.Dial(t, srv, grpctest.DialOptions(grpc.WithUnaryInterceptor(UnaryClientInterceptor(p, helloMethod))))
+	start := time.Now()
+	if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("retried after %s, want about MaxRetryDelay", d)
+	}
+}
@@ -0,0 +1,286 @@
+// Package tlsutil builds gRPC transport credentials from PEM files and
+// reloads the certificates when the files change, so they can be rotated
+// without restarting the server or the client.
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+type Config struct {
+	CertFile string `envconfig:"CERT_FILE"`
+	KeyFile  string `envconfig:"KEY_FILE"`
+	// CAFile verifies the peer: client certificates on the server, the
+	// server certificate on the client. Clients fall back to the system
+	// roots when it is empty.
+	CAFile string `envconfig:"CA_FILE"`
+	// ClientAuth makes the server require a client certificate signed by
+	// CAFile.
+	ClientAuth bool `envconfig:"CLIENT_AUTH"`
+	// ServerName overrides the name clients verify the server against.
+	ServerName string `envconfig:"SERVER_NAME"`
+	// ReloadInterval is how often the files are checked for changes.
+	// Zero means DefaultReloadInterval.
+	ReloadInterval time.Duration `envconfig:"RELOAD_INTERVAL"`
+}
+
+const DefaultReloadInterval = 10 * time.Second
+
+func (c Config) interval() time.Duration {
+	if c.ReloadInterval > 0 {
+		return c.ReloadInterval
+	}
+	return DefaultReloadInterval
+}
+
+// ServerCredentials returns TLS credentials for grpc.Creds. CertFile and
+// KeyFile are required.
+func ServerCredentials(cfg Config) (credentials.TransportCredentials, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tlsutil: server needs a certificate and a key")
+	}
+	if cfg.ClientAuth && cfg.CAFile == "" {
+		return nil, errors.New("tlsutil: client authentication needs a CA file")
+	}
+
+	keyPair, err := NewKeyPair(cfg.CertFile, cfg.KeyFile, cfg.interval())
+	if err != nil {
+		return nil, err
+	}
+	var ca *CertPool
+	if cfg.CAFile != "" {
+		if ca, err = NewCertPool(cfg.CAFile, cfg.interval()); err != nil {
+			return nil, err
+		}
+	}
+
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: keyPair.GetCertificate,
+	}
+	if ca != nil {
+		// the pool is looked up per handshake so a rotated CA is used too
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool, err := ca.Pool()
+			if err != nil {
+				return nil, err
+			}
+			c := base.Clone()
+			c.GetConfigForClient = nil
+			c.ClientCAs = pool
+			if cfg.ClientAuth {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+			} else {
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return c, nil
+		}
+	}
+	return credentials.NewTLS(base), nil
+}
+
+// ClientCredentials returns TLS credentials for grpc.WithTransportCredentials.
+// A client certificate is presented when CertFile and KeyFile are set; one
+// without the other is an error.
+func ClientCredentials(cfg Config) (credentials.TransportCredentials, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("tlsutil: a client certificate needs both a certificate and a key")
+	}
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CertFile != "" {
+		keyPair, err := NewKeyPair(cfg.CertFile, cfg.KeyFile, cfg.interval())
+		if err != nil {
+			return nil, err
+		}
+		c.GetClientCertificate = keyPair.GetClientCertificate
+	}
+
+	if cfg.CAFile == "" {
+		return credentials.NewTLS(c), nil
+	}
+	ca, err := NewCertPool(cfg.CAFile, cfg.interval())
+	if err != nil {
+		return nil, err
+	}
+	return &clientCredentials{TransportCredentials: credentials.NewTLS(c), base: c, ca: ca}, nil
+}
+
+// clientCredentials verifies the server against the current pool of ca on
+// every handshake, the client side of what GetConfigForClient does for
+// the server.
+type clientCredentials struct {
+	credentials.TransportCredentials
+	base *tls.Config
+	ca   *CertPool
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	pool, err := c.ca.Pool()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := c.base.Clone()
+	cfg.RootCAs = pool
+	return credentials.NewTLS(cfg).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	base := c.base.Clone()
+	return &clientCredentials{TransportCredentials: credentials.NewTLS(base), base: base, ca: c.ca}
+}
+
+func (c *clientCredentials) OverrideServerName(name string) error {
+	c.base.ServerName = name
+	return c.TransportCredentials.OverrideServerName(name)
+}
+
+// fileWatch tells whether a set of files changed since the last call,
+// looking at them at most once per interval.
+type fileWatch struct {
+	files    []string
+	interval time.Duration
+	checked  time.Time
+	modTimes []time.Time
+}
+
+func (w *fileWatch) changed(now time.Time) bool {
+	if now.Sub(w.checked) < w.interval {
+		return false
+	}
+	w.checked = now
+
+	modTimes := make([]time.Time, len(w.files))
+	for i, f := range w.files {
+		info, err := os.Stat(f)
+		if err != nil {
+			// a file being replaced; keep what is loaded and look again later
+			return false
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	changed := false
+	for i := range modTimes {
+		if i >= len(w.modTimes) || !modTimes[i].Equal(w.modTimes[i]) {
+			changed = true
+		}
+	}
+	w.modTimes = modTimes
+	return changed
+}
+
+// KeyPair is a certificate and key loaded from PEM files, reloaded when
+// either file changes. A reload that fails keeps the previous pair.
+type KeyPair struct {
+	certFile string
+	keyFile  string
+
+	mutex sync.Mutex
+	watch fileWatch
+	cert  *tls.Certificate
+}
+
+func NewKeyPair(certFile, keyFile string, interval time.Duration) (*KeyPair, error) {
+	kp := &KeyPair{
+		certFile: certFile,
+		keyFile:  keyFile,
+		watch:    fileWatch{files: []string{certFile, keyFile}, interval: interval},
+	}
+	kp.watch.changed(time.Now())
+	if err := kp.load(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+func (kp *KeyPair) load() error {
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return fmt.Errorf("tlsutil: %w", err)
+	}
+	kp.cert = &cert
+	return nil
+}
+
+// Certificate returns the current pair, reloading it first if the files
+// have changed.
+func (kp *KeyPair) Certificate() *tls.Certificate {
+	kp.mutex.Lock()
+	defer kp.mutex.Unlock()
+
+	if kp.watch.changed(time.Now()) {
+		// the key may not have been written yet; retry on the next check
+		if err := kp.load(); err != nil {
+			kp.watch.modTimes = nil
+		}
+	}
+	return kp.cert
+}
+
+func (kp *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return kp.Certificate(), nil
+}
+
+func (kp *KeyPair) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return kp.Certificate(), nil
+}
+
+// CertPool is a CA bundle loaded from a PEM file, reloaded when it changes.
+type CertPool struct {
+	file string
+
+	mutex sync.Mutex
+	watch fileWatch
+	pool  *x509.CertPool
+}
+
+func NewCertPool(file string, interval time.Duration) (*CertPool, error) {
+	cp := &CertPool{
+		file:  file,
+		watch: fileWatch{files: []string{file}, interval: interval},
+	}
+	cp.watch.changed(time.Now())
+	if err := cp.load(); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *CertPool) load() error {
+	b, err := os.ReadFile(cp.file)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("tlsutil: %s: no certificates found", cp.file)
+	}
+	cp.pool = pool
+	return nil
+}
+
+func (cp *CertPool) Pool() (*x509.CertPool, error) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if cp.watch.changed(time.Now()) {
+		if err := cp.load(); err != nil {
+			cp.watch.modTimes = nil
+		}
+	}
+	return cp.pool, nil
+}
@@ -0,0 +1,161 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// handshake runs both sides of a TLS handshake over a loopback connection
+// and returns the connection state seen by the client, or the first error.
+func handshake(t *testing.T, server, client credentials.TransportCredentials) (*tls.ConnectionState, error) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	cc, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	sc, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, _, err := server.ServerHandshake(sc)
+		if err == nil {
+			conn.Close()
+		}
+		serverErr <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, info, err := client.ClientHandshake(ctx, "localhost", cc)
+	if err != nil {
+		cc.Close()
+		<-serverErr
+		return nil, err
+	}
+	defer conn.Close()
+	if err := <-serverErr; err != nil {
+		return nil, err
+	}
+	state := info.(credentials.TLSInfo).State
+	return &state, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	files, err := Generate(t.TempDir(), []string{"localhost"}, "test-client", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := ServerCredentials(Config{
+		CertFile:   files.ServerCert,
+		KeyFile:    files.ServerKey,
+		CAFile:     files.CACert,
+		ClientAuth: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withCert, err := ClientCredentials(Config{
+		CAFile:   files.CACert,
+		CertFile: files.ClientCert,
+		KeyFile:  files.ClientKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, server, withCert); err != nil {
+		t.Errorf("handshake with client certificate: %v", err)
+	}
+
+	withoutCert, err := ClientCredentials(Config{CAFile: files.CACert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, server, withoutCert); err == nil {
+		t.Error("handshake without client certificate succeeded")
+	}
+}
+
+func TestServerRequiresKeyPair(t *testing.T) {
+	if _, err := ServerCredentials(Config{}); err == nil {
+		t.Error("no error without certificate")
+	}
+	if _, err := ServerCredentials(Config{CertFile: "a", KeyFile: "b", ClientAuth: true}); err == nil {
+		t.Error("no error for client auth without CA")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	files, err := Generate(dir, []string{"localhost"}, "test-client", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := ServerCredentials(Config{
+		CertFile:       files.ServerCert,
+		KeyFile:        files.ServerKey,
+		CAFile:         files.CACert,
+		ReloadInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a client checking once per hour keeps the old CA for this test
+	stale, err := ClientCredentials(Config{CAFile: files.CACert, ReloadInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ClientCredentials(Config{CAFile: files.CACert, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := handshake(t, server, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := state.PeerCertificates[0].SerialNumber
+
+	// regenerating replaces the CA as well, so only a client that reloads
+	// it still trusts the server
+	time.Sleep(10 * time.Millisecond)
+	if _, err := Generate(dir, []string{"localhost"}, "test-client", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, server, stale); err == nil {
+		t.Error("old CA still verifies the rotated certificate")
+	}
+
+	state, err = handshake(t, server, client)
+	if err != nil {
+		t.Fatalf("handshake after rotation: %v", err)
+	}
+	if state.PeerCertificates[0].SerialNumber.Cmp(before) == 0 {
+		t.Error("server still presents the old certificate")
+	}
+}
+
+func TestClientRequiresCertAndKey(t *testing.T) {
+	if _, err := ClientCredentials(Config{KeyFile: "client-key.pem"}); err == nil {
+		t.Error("no error for a key without a certificate")
+	}
+	if _, err := ClientCredentials(Config{CertFile: "client.pem"}); err == nil {
+		t.Error("no error for a certificate without a key")
+	}
+}
@@ -0,0 +1,235 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "mygrpc/pkg/tracing"
+
+// propagator reads and writes the traceparent and tracestate headers.
+var propagator = propagation.TraceContext{}
+
+// metadataCarrier lets the propagator use gRPC metadata as headers.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func inject(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+func extract(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// rpcAttributes follow the OpenTelemetry semantic conventions for RPC.
+func rpcAttributes(fullMethod string) []attribute.KeyValue {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", method),
+	}
+}
+
+func spanName(fullMethod string) string {
+	return strings.TrimPrefix(fullMethod, "/")
+}
+
+// end records the status of err on span and ends it.
+func end(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(code)))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// UnaryServerInterceptor starts a server span for each call, continuing
+// the trace of the caller when it sent a traceparent.
+func UnaryServerInterceptor(tp trace.TracerProvider) grpc.UnaryServerInterceptor {
+	tracer := tp.Tracer(instrumentationName)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := tracer.Start(extract(ctx), spanName(info.FullMethod),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(rpcAttributes(info.FullMethod)...),
+		)
+		res, err := handler(ctx, req)
+		end(span, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor starts a server span for each stream, with a
+// child span for every message sent or received.
+func StreamServerInterceptor(tp trace.TracerProvider) grpc.StreamServerInterceptor {
+	tracer := tp.Tracer(instrumentationName)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := tracer.Start(extract(ss.Context()), spanName(info.FullMethod),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(rpcAttributes(info.FullMethod)...),
+		)
+		err := handler(srv, &serverStream{
+			ServerStream: ss,
+			messages:     messages{ctx: ctx, tracer: tracer, name: spanName(info.FullMethod)},
+		})
+		end(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor starts a client span for each call and sends its
+// context to the server.
+func UnaryClientInterceptor(tp trace.TracerProvider) grpc.UnaryClientInterceptor {
+	tracer := tp.Tracer(instrumentationName)
+	return func(ctx context.Context, method string, req, res interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := tracer.Start(ctx, spanName(method),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(rpcAttributes(method)...),
+		)
+		err := invoker(inject(ctx), method, req, res, cc, opts...)
+		end(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a client span for each stream, ended when
+// the stream finishes, with a child span for every message.
+func StreamClientInterceptor(tp trace.TracerProvider) grpc.StreamClientInterceptor {
+	tracer := tp.Tracer(instrumentationName)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := tracer.Start(ctx, spanName(method),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(rpcAttributes(method)...),
+		)
+		cs, err := streamer(inject(ctx), desc, cc, method, opts...)
+		if err != nil {
+			end(span, err)
+			return nil, err
+		}
+		return &clientStream{
+			ClientStream: cs,
+			span:         span,
+			desc:         desc,
+			messages:     messages{ctx: ctx, tracer: tracer, name: spanName(method)},
+		}, nil
+	}
+}
+
+// messages starts the span of one stream message under the RPC span.
+type messages struct {
+	ctx    context.Context
+	tracer trace.Tracer
+	name   string
+}
+
+func (m messages) start(direction string) trace.Span {
+	_, span := m.tracer.Start(m.ctx, m.name+"/"+direction,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attribute.String("message.type", strings.ToUpper(direction))),
+	)
+	return span
+}
+
+func endMessage(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, io.EOF) {
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	messages messages
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.messages.ctx
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	span := s.messages.start("sent")
+	err := s.ServerStream.SendMsg(m)
+	endMessage(span, err)
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	span := s.messages.start("received")
+	err := s.ServerStream.RecvMsg(m)
+	endMessage(span, err)
+	return err
+}
+
+// clientStream ends the RPC span when the stream reports its final status:
+// io.EOF or an error from RecvMsg, or the response of a client stream.
+type clientStream struct {
+	grpc.ClientStream
+	span     trace.Span
+	desc     *grpc.StreamDesc
+	messages messages
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	span := s.messages.start("sent")
+	err := s.ClientStream.SendMsg(m)
+	endMessage(span, err)
+	if err != nil && !errors.Is(err, io.EOF) {
+		end(s.span, err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	span := s.messages.start("received")
+	err := s.ClientStream.RecvMsg(m)
+	endMessage(span, err)
+	switch {
+	case errors.Is(err, io.EOF):
+		end(s.span, nil)
+	case err != nil:
+		end(s.span, err)
+	case !s.desc.ServerStreams:
+		// a client stream has a single response
+		end(s.span, nil)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+// Package tracing sets up OpenTelemetry tracing for the server and client
+// and carries the W3C trace context through gRPC metadata.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter string `envconfig:"EXPORTER" default:"none"`
+	// Endpoint is the host:port of the OTLP/HTTP collector. When empty the
+	// exporter falls back to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318.
+	Endpoint string `envconfig:"ENDPOINT"`
+	Insecure bool   `envconfig:"INSECURE"`
+	// SampleRatio is the share of new traces recorded. Calls that carry a
+	// sampled parent are always recorded.
+	SampleRatio float64 `envconfig:"SAMPLE_RATIO" default:"1"`
+}
+
+func (c Config) Enabled() bool {
+	return c.Exporter != "" && c.Exporter != "none"
+}
+
+// NewProvider returns a provider exporting spans as cfg says, or a no-op
+// one when tracing is disabled. Shutdown flushes the spans still buffered.
+func NewProvider(ctx context.Context, serviceName string, cfg Config) (trace.TracerProvider, func(context.Context) error, error) {
+	if !cfg.Enabled() {
+		return trace.NewNoopTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "stdout":
+		var err error
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout)); err != nil {
+			return nil, nil, err
+		}
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		var err error
+		if exporter, err = otlptracehttp.New(ctx, opts...); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q, want none, stdout or otlp", cfg.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+		)),
+	)
+	return tp, tp.Shutdown, nil
+}
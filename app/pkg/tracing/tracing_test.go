@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"mygrpc/internal/grpctest"
+	hellopb "mygrpc/pkg/grpc"
+)
+
+func dial(t *testing.T, server, client trace.TracerProvider) hellopb.GreetingServiceClient {
+	t.Helper()
+
+	return grpctest.Dial(t, grpctest.Greeter{},
+		grpctest.ServerOptions(
+			grpc.UnaryInterceptor(UnaryServerInterceptor(server)),
+			grpc.StreamInterceptor(StreamServerInterceptor(server)),
+		),
+		grpctest.DialOptions(
+			grpc.WithUnaryInterceptor(UnaryClientInterceptor(client)),
+			grpc.WithStreamInterceptor(StreamClientInterceptor(client)),
+		),
+	)
+}
+
+func spansNamed(spans []sdktrace.ReadOnlySpan, name string) []sdktrace.ReadOnlySpan {
+	var found []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if s.Name() == name {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+func TestUnaryPropagation(t *testing.T) {
+	serverSpans := tracetest.NewSpanRecorder()
+	clientSpans := tracetest.NewSpanRecorder()
+	client := dial(t,
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(serverSpans)),
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(clientSpans)),
+	)
+
+	if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Hello(context.Background(), &hellopb.HelloRequest{}); err == nil {
+		t.Fatal("empty name accepted")
+	}
+
+	cs := clientSpans.Ended()
+	ss := serverSpans.Ended()
+	if len(cs) != 2 || len(ss) != 2 {
+		t.Fatalf("got %d client and %d server spans, want 2 each", len(cs), len(ss))
+	}
+	for i := range cs {
+		if cs[i].Name() != "myapp.GreetingService/Hello" || cs[i].SpanKind() != trace.SpanKindClient {
+			t.Errorf("client span %q kind %v", cs[i].Name(), cs[i].SpanKind())
+		}
+		if ss[i].SpanKind() != trace.SpanKindServer {
+			t.Errorf("server span kind %v", ss[i].SpanKind())
+		}
+		if ss[i].SpanContext().TraceID() != cs[i].SpanContext().TraceID() {
+			t.Errorf("server span is in trace %v, client in %v", ss[i].SpanContext().TraceID(), cs[i].SpanContext().TraceID())
+		}
+		if ss[i].Parent().SpanID() != cs[i].SpanContext().SpanID() {
+			t.Errorf("server span parent = %v, want the client span %v", ss[i].Parent().SpanID(), cs[i].SpanContext().SpanID())
+		}
+	}
+	if ss[0].Status().Code != codes.Unset || ss[1].Status().Code != codes.Error {
+		t.Errorf("server span statuses = %v, %v; want Unset, Error", ss[0].Status().Code, ss[1].Status().Code)
+	}
+}
+
+func TestStreamMessageSpans(t *testing.T) {
+	serverSpans := tracetest.NewSpanRecorder()
+	clientSpans := tracetest.NewSpanRecorder()
+	client := dial(t,
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(serverSpans)),
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(clientSpans)),
+	)
+
+	stream, err := client.HelloBiStreams(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := stream.Send(&hellopb.HelloRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Recv after CloseSend: %v", err)
+	}
+
+	const name = "myapp.GreetingService/HelloBiStreams"
+	rpc := spansNamed(clientSpans.Ended(), name)
+	if len(rpc) != 1 {
+		t.Fatalf("got %d client stream spans, want 1", len(rpc))
+	}
+	// the final Recv returning io.EOF gets a span too
+	if got := len(spansNamed(clientSpans.Ended(), name+"/sent")); got != 2 {
+		t.Errorf("client sent spans = %d, want 2", got)
+	}
+	if got := len(spansNamed(clientSpans.Ended(), name+"/received")); got != 3 {
+		t.Errorf("client received spans = %d, want 3", got)
+	}
+
+	server := spansNamed(serverSpans.Ended(), name)
+	if len(server) != 1 {
+		t.Fatalf("got %d server stream spans, want 1", len(server))
+	}
+	if server[0].Parent().SpanID() != rpc[0].SpanContext().SpanID() {
+		t.Error("server stream span is not a child of the client span")
+	}
+	for _, s := range spansNamed(serverSpans.Ended(), name+"/sent") {
+		if s.Parent().SpanID() != server[0].SpanContext().SpanID() {
+			t.Errorf("message span %v is not a child of the stream span", s.SpanContext().SpanID())
+		}
+	}
+	if got := len(spansNamed(serverSpans.Ended(), name+"/sent")); got != 2 {
+		t.Errorf("server sent spans = %d, want 2", got)
+	}
+}
+
+// collector stands in for an OTLP/HTTP collector and keeps the names of
+// the spans it receives.
+type collector struct {
+	mutex sync.Mutex
+	names []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req collectorpb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, rs := range req.GetResourceSpans() {
+		for _, ss := range rs.GetScopeSpans() {
+			for _, s := range ss.GetSpans() {
+				c.names = append(c.names, s.GetName())
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	b, _ = proto.Marshal(&collectorpb.ExportTraceServiceResponse{})
+	w.Write(b)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	ctx := context.Background()
+	tp, shutdown, err := NewProvider(ctx, "test", Config{
+		Exporter:    "otlp",
+		Endpoint:    strings.TrimPrefix(srv.URL, "http://"),
+		Insecure:    true,
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dial(t, trace.NewNoopTracerProvider(), tp)
+	if _, err := client.Hello(ctx, &hellopb.HelloRequest{Name: "gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.names) != 1 || c.names[0] != "myapp.GreetingService/Hello" {
+		t.Errorf("collector received %q", c.names)
+	}
+}
+
+func TestNewProviderUnknownExporter(t *testing.T) {
+	if _, _, err := NewProvider(context.Background(), "test", Config{Exporter: "zipkin"}); err == nil {
+		t.Error("no error for unknown exporter")
+	}
+}
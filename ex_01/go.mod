@@ -2,4 +2,4 @@ module ex_01
 
 go 1.19
 
-require github.com/kelseyhightower/envconfig v1.4.0 // indirect
+require github.com/kelseyhightower/envconfig v1.4.0
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Backoff retries an operation with exponential, jittered delays.
+type Backoff struct {
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+}
+
+var defaultBackoff = Backoff{Attempts: 3, Initial: 200 * time.Millisecond, Max: 5 * time.Second}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent marks err as not worth retrying.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Retry calls fn until it succeeds, returns a permanent error, the attempts
+// run out or ctx is done. It returns the last error of fn.
+func (b Backoff) Retry(ctx context.Context, fn func() error) error {
+	attempts := b.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	delay := b.Initial
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if i == attempts-1 {
+			break
+		}
+
+		// jitter keeps many senders from retrying in lockstep
+		wait := delay
+		if wait > 0 {
+			wait = time.Duration(rand.Int63n(int64(wait))) + wait/2
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		delay *= 2
+		if b.Max > 0 && delay > b.Max {
+			delay = b.Max
+		}
+	}
+	return err
+}
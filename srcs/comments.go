@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+
+	maxMessageLength  = 1000
+	maxUserNameLength = 64
+)
+
+type Comment struct {
+	ID        int64     `json:"id"`
+	Message   string    `json:"message"`
+	UserName  string    `json:"user_name"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate reports every field of c that may not be stored.
+func (c Comment) Validate() []FieldError {
+	var errs []FieldError
+	check := func(field, value string, max int) {
+		switch {
+		case strings.TrimSpace(value) == "":
+			errs = append(errs, FieldError{Field: field, Message: "is required"})
+		case utf8.RuneCountInString(value) > max:
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("must be at most %d characters", max)})
+		}
+	}
+	check("message", c.Message, maxMessageLength)
+	check("user_name", c.UserName, maxUserNameLength)
+	return errs
+}
+
+// commentInput is the body accepted by POST and PUT. The server owns the
+// ID and timestamps, so clients cannot send them.
+type commentInput struct {
+	Message  string `json:"message"`
+	UserName string `json:"user_name"`
+	Version  int    `json:"version"`
+}
+
+// commentPatch holds the fields a PATCH request may change. Nil fields are
+// left untouched.
+type commentPatch struct {
+	Message  *string `json:"message"`
+	UserName *string `json:"user_name"`
+	Version  int     `json:"version"`
+}
+
+type commentsHandler struct {
+	store CommentStore
+}
+
+// comments serves the collection at /comments and single comments at
+// /comments/{id}.
+func comments(store CommentStore) http.Handler {
+	return &commentsHandler{store: store}
+}
+
+func (h *commentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/comments"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r)
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeProblem(w, r, &HTTPError{StatusCode: http.StatusMethodNotAllowed, Detail: "permits only GET or POST"})
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(w, r, ErrNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, id)
+	case http.MethodPut:
+		h.replace(w, r, id)
+	case http.MethodPatch:
+		h.patch(w, r, id)
+	case http.MethodDelete:
+		h.delete(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		writeProblem(w, r, &HTTPError{StatusCode: http.StatusMethodNotAllowed, Detail: "permits only GET, PUT, PATCH or DELETE"})
+	}
+}
+
+func (h *commentsHandler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := ListOptions{
+		UserName: q.Get("user"),
+		Limit:    defaultListLimit,
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeProblem(w, r, badRequest("invalid query parameter",
+				FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxListLimit)}))
+			return
+		}
+		opts.Limit = limit
+	}
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || cursor < 0 {
+			writeProblem(w, r, badRequest("invalid query parameter",
+				FieldError{Field: "cursor", Message: "must be a cursor returned by a previous page"}))
+			return
+		}
+		opts.Cursor = cursor
+	}
+
+	// fetch one extra comment to know whether there is a next page
+	limit := opts.Limit
+	opts.Limit++
+	comments, err := h.store.List(r.Context(), opts)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if len(comments) > limit {
+		comments = comments[:limit]
+		next := *r.URL
+		q.Set("cursor", strconv.FormatInt(comments[limit-1].ID, 10))
+		next.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (h *commentsHandler) create(w http.ResponseWriter, r *http.Request) {
+	var in commentInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	c := Comment{Message: in.Message, UserName: in.UserName}
+	if err := invalid(c.Validate()...); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	c, err := h.store.Create(r.Context(), c)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/comments/%d", c.ID))
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (h *commentsHandler) get(w http.ResponseWriter, r *http.Request, id int64) {
+	c, err := h.store.Get(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *commentsHandler) replace(w http.ResponseWriter, r *http.Request, id int64) {
+	var in commentInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	c := Comment{ID: id, Message: in.Message, UserName: in.UserName, Version: in.Version}
+	if err := invalid(c.Validate()...); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	c, err := h.store.Update(r.Context(), c)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *commentsHandler) patch(w http.ResponseWriter, r *http.Request, id int64) {
+	var p commentPatch
+	if err := decodeJSON(w, r, &p); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	c, err := h.store.Get(r.Context(), id)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	if p.Version != 0 && p.Version != c.Version {
+		writeProblem(w, r, ErrConflict)
+		return
+	}
+	if p.Message != nil {
+		c.Message = *p.Message
+	}
+	if p.UserName != nil {
+		c.UserName = *p.UserName
+	}
+	if err := invalid(c.Validate()...); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+
+	// c.Version guards against a change between Get and Update
+	c, err = h.store.Update(r.Context(), c)
+	if err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *commentsHandler) delete(w http.ResponseWriter, r *http.Request, id int64) {
+	if err := h.store.Delete(r.Context(), id); err != nil {
+		writeProblem(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
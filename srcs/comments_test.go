@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCommentsResource(t *testing.T) {
+	srv := httptest.NewServer(comments(NewMemoryCommentStore()))
+	defer srv.Close()
+
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	for _, name := range []string{"gopher", "alice", "gopher"} {
+		res := do(http.MethodPost, "/comments", `{"message":"hi","user_name":"`+name+`"}`)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("POST status = %d, want %d", res.StatusCode, http.StatusCreated)
+		}
+	}
+
+	res := do(http.MethodGet, "/comments/1", "")
+	var c Comment
+	if err := json.NewDecoder(res.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 1 || c.UserName != "gopher" || c.Version != 1 {
+		t.Errorf("GET /comments/1 = %+v", c)
+	}
+
+	res = do(http.MethodGet, "/comments?user=gopher&limit=1", "")
+	var page []Comment
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != 1 {
+		t.Errorf("first page = %+v", page)
+	}
+	if link := res.Header.Get("Link"); !strings.Contains(link, "cursor=1") {
+		t.Errorf("Link = %q, want a next cursor", link)
+	}
+
+	if res := do(http.MethodPatch, "/comments/1", `{"message":"edited","version":1}`); res.StatusCode != http.StatusOK {
+		t.Errorf("PATCH status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if res := do(http.MethodPut, "/comments/1", `{"message":"stale","user_name":"gopher","version":1}`); res.StatusCode != http.StatusConflict {
+		t.Errorf("stale PUT status = %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+	if res := do(http.MethodDelete, "/comments/2", ""); res.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+	if res := do(http.MethodGet, "/comments/2", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET deleted status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestCommentsProblem(t *testing.T) {
+	srv := httptest.NewServer(comments(NewMemoryCommentStore()))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{name: "malformed", body: `{"message":`, status: http.StatusBadRequest},
+		{name: "unknown field", body: `{"message":"hi","user_name":"gopher","id":3}`, status: http.StatusBadRequest, field: "id"},
+		{name: "wrong type", body: `{"message":1,"user_name":"gopher"}`, status: http.StatusBadRequest, field: "message"},
+		{name: "missing user", body: `{"message":"hi"}`, status: http.StatusUnprocessableEntity, field: "user_name"},
+		{name: "too long", body: `{"message":"` + strings.Repeat("a", maxMessageLength+1) + `","user_name":"gopher"}`, status: http.StatusUnprocessableEntity, field: "message"},
+		{name: "too large", body: `{"message":"` + strings.Repeat("a", maxBodyBytes) + `"}`, status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		res, err := http.Post(srv.URL+"/comments", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p problem
+		err = json.NewDecoder(res.Body).Decode(&p)
+		res.Body.Close()
+		if err != nil {
+			t.Errorf("%s: invalid problem body: %v", tt.name, err)
+			continue
+		}
+
+		if res.StatusCode != tt.status || p.Status != tt.status {
+			t.Errorf("%s: status = %d (body %d), want %d", tt.name, res.StatusCode, p.Status, tt.status)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: Content-Type = %q", tt.name, ct)
+		}
+		if tt.field != "" && (len(p.Errors) != 1 || p.Errors[0].Field != tt.field) {
+			t.Errorf("%s: errors = %+v, want one for %q", tt.name, p.Errors, tt.field)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package convert streams tabular data between CSV, TSV, JSON arrays and
+// JSON Lines.
+//
+// Records are read and written one at a time, so inputs larger than memory
+// can be converted. Only the first Options.SampleSize records are buffered
+// to infer the column types of text input and the columns of JSON input.
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+type Format string
+
+const (
+	CSV   Format = "csv"
+	TSV   Format = "tsv"
+	JSON  Format = "json"
+	JSONL Format = "jsonl"
+)
+
+// ParseFormat accepts a format name or a file name with a known extension.
+func ParseFormat(s string) (Format, error) {
+	name := strings.ToLower(s)
+	if ext := filepath.Ext(name); ext != "" {
+		name = ext[1:]
+	}
+	switch Format(name) {
+	case CSV, TSV, JSON, JSONL:
+		return Format(name), nil
+	case "ndjson":
+		return JSONL, nil
+	}
+	return "", fmt.Errorf("unknown format: %q", s)
+}
+
+func (f Format) text() bool {
+	return f == CSV || f == TSV
+}
+
+// Record is one row. Values of text input are strings until types are
+// inferred; values of JSON input are what encoding/json decodes, with
+// numbers kept as json.Number.
+type Record struct {
+	Columns []string
+	Values  []interface{}
+}
+
+func (r Record) index(column string) int {
+	for i, c := range r.Columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+type Reader interface {
+	// Read returns io.EOF after the last record.
+	Read() (Record, error)
+}
+
+// headerReader is implemented by readers that know their columns before the
+// first record, so that text input with only a header keeps it.
+type headerReader interface {
+	header() ([]string, error)
+}
+
+type Writer interface {
+	Write(r Record) error
+	// Close finishes the output, e.g. the closing bracket of a JSON array,
+	// without closing the underlying io.Writer.
+	Close() error
+}
+
+const DefaultSampleSize = 1000
+
+type Options struct {
+	From Format
+	To   Format
+
+	// Select keeps only these columns, in this order. Names refer to the
+	// input, before Rename is applied.
+	Select []string
+	// Rename maps input column names to output column names.
+	Rename map[string]string
+	// SampleSize is the number of records used for schema inference.
+	// Zero means DefaultSampleSize.
+	SampleSize int
+	// NoInference keeps every value of text input as a string.
+	NoInference bool
+}
+
+func NewReader(r io.Reader, f Format) (Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	switch f {
+	case CSV:
+		return newTextReader(br, ','), nil
+	case TSV:
+		return newTextReader(br, '\t'), nil
+	case JSON, JSONL:
+		return newJSONReader(br), nil
+	}
+	return nil, fmt.Errorf("unknown input format: %q", f)
+}
+
+func NewWriter(w io.Writer, f Format) (Writer, error) {
+	switch f {
+	case CSV:
+		return newTextWriter(w, ','), nil
+	case TSV:
+		return newTextWriter(w, '\t'), nil
+	case JSON:
+		return newJSONWriter(w, true), nil
+	case JSONL:
+		return newJSONWriter(w, false), nil
+	}
+	return nil, fmt.Errorf("unknown output format: %q", f)
+}
+
+// Convert copies every record of r to w.
+func Convert(r io.Reader, w io.Writer, opts Options) error {
+	in, err := NewReader(r, opts.From)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<16)
+	out, err := NewWriter(bw, opts.To)
+	if err != nil {
+		return err
+	}
+
+	sample := opts.SampleSize
+	if sample <= 0 {
+		sample = DefaultSampleSize
+	}
+	switch {
+	case opts.From.text() && !opts.To.text() && !opts.NoInference:
+		in = inferTypes(in, sample)
+	case !opts.From.text():
+		in = inferColumns(in, sample)
+	}
+	if len(opts.Select) > 0 || len(opts.Rename) > 0 {
+		in = &projectReader{r: in, selected: opts.Select, rename: opts.Rename}
+	}
+
+	if err := Copy(out, in); err != nil {
+		return err
+	}
+	if err := writeHeader(out, in); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Copy writes records from r to w until r returns io.EOF.
+func Copy(w Writer, r Reader) error {
+	for n := 1; ; n++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+		if err := w.Write(rec); err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+	}
+}
+
+// writeHeader writes the header of text output that got no records, if the
+// input has one.
+func writeHeader(w Writer, r Reader) error {
+	tw, ok := w.(*textWriter)
+	if !ok || tw.wroteHeader {
+		return nil
+	}
+	h, ok := r.(headerReader)
+	if !ok {
+		return nil
+	}
+	columns, err := h.header()
+	if err != nil || columns == nil {
+		return err
+	}
+	return tw.writeHeader(columns)
+}
+
+// projectReader applies Options.Select and Options.Rename.
+type projectReader struct {
+	r        Reader
+	selected []string
+	rename   map[string]string
+
+	columns []string
+	from    []int
+}
+
+func (p *projectReader) Read() (Record, error) {
+	rec, err := p.r.Read()
+	if err != nil {
+		return Record{}, err
+	}
+
+	if p.columns == nil {
+		if err := p.project(rec); err != nil {
+			return Record{}, err
+		}
+	}
+
+	values := make([]interface{}, len(p.from))
+	for i, j := range p.from {
+		if j < len(rec.Values) {
+			values[i] = rec.Values[j]
+		}
+	}
+	return Record{Columns: p.columns, Values: values}, nil
+}
+
+// project maps the columns of the first record to the output columns.
+func (p *projectReader) project(rec Record) error {
+	selected := p.selected
+	if len(selected) == 0 {
+		selected = rec.Columns
+	}
+	for _, c := range selected {
+		i := rec.index(c)
+		if i < 0 {
+			return fmt.Errorf("unknown column %q", c)
+		}
+		p.from = append(p.from, i)
+		if to, ok := p.rename[c]; ok {
+			c = to
+		}
+		p.columns = append(p.columns, c)
+	}
+	for c := range p.rename {
+		if rec.index(c) < 0 {
+			return fmt.Errorf("cannot rename unknown column %q", c)
+		}
+	}
+	return nil
+}
+
+func (p *projectReader) header() ([]string, error) {
+	if p.columns != nil {
+		return p.columns, nil
+	}
+	h, ok := p.r.(headerReader)
+	if !ok {
+		return nil, nil
+	}
+	columns, err := h.header()
+	if err != nil || columns == nil {
+		return nil, err
+	}
+	if err := p.project(Record{Columns: columns}); err != nil {
+		return nil, err
+	}
+	return p.columns, nil
+}
@@ -0,0 +1,102 @@
+package convert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		opts Options
+		want string
+	}{
+		{
+			name: "csv to json",
+			in:   "country,iso,population,zip,ratio\nusa,US/USA,310,02134,1\njapan,JP/JPN,127,,0.5\n",
+			opts: Options{From: CSV, To: JSON},
+			want: "[\n" +
+				`{"country":"usa","iso":"US/USA","population":310,"zip":"02134","ratio":1},` + "\n" +
+				`{"country":"japan","iso":"JP/JPN","population":127,"zip":"","ratio":0.5}` +
+				"\n]\n",
+		},
+		{
+			name: "json to csv",
+			in:   `[{"title":"aaa","tags":["go","csv"]},{"title":"b,b","price":1.5e3,"tags":null}]`,
+			opts: Options{From: JSON, To: CSV},
+			want: "title,tags,price\naaa,\"[\"\"go\"\",\"\"csv\"\"]\",\n\"b,b\",,1.5e3\n",
+		},
+		{
+			name: "jsonl to tsv with select and rename",
+			in:   "{\"origin\":\"255.255.255.255\",\"url\":\"https://httpbin.org/net\"}\n{\"url\":\"x\",\"origin\":\"y\"}\n",
+			opts: Options{From: JSONL, To: TSV, Select: []string{"url", "origin"}, Rename: map[string]string{"origin": "ip"}},
+			want: "url\tip\nhttps://httpbin.org/net\t255.255.255.255\nx\ty\n",
+		},
+		{
+			name: "tsv to jsonl without inference",
+			in:   "name\tyear\nGo lang thread\t2018\n",
+			opts: Options{From: TSV, To: JSONL, NoInference: true},
+			want: `{"name":"Go lang thread","year":"2018"}` + "\n",
+		},
+		{
+			name: "csv header only",
+			in:   "country,iso,population\n",
+			opts: Options{From: CSV, To: TSV, Select: []string{"iso", "country"}},
+			want: "iso\tcountry\n",
+		},
+		{
+			name: "empty array",
+			in:   "[]",
+			opts: Options{From: JSON, To: JSON},
+			want: "[]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := Convert(strings.NewReader(tt.in), &out, tt.opts); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		opts Options
+		want string
+	}{
+		{
+			name: "column outside the sample",
+			in:   `{"a":1}` + "\n" + `{"b":2}`,
+			opts: Options{From: JSONL, To: CSV, SampleSize: 1},
+			want: `record 2: column "b"`,
+		},
+		{
+			name: "value against the inferred type",
+			in:   "n\n1\n2\nthree\n",
+			opts: Options{From: CSV, To: JSON, SampleSize: 2},
+			want: `record 3: column "n"`,
+		},
+		{
+			name: "unknown selected column",
+			in:   "a\n1\n",
+			opts: Options{From: CSV, To: CSV, Select: []string{"b"}},
+			want: `unknown column "b"`,
+		},
+	}
+
+	for _, tt := range tests {
+		err := Convert(strings.NewReader(tt.in), &bytes.Buffer{}, tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
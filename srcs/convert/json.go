@@ -0,0 +1,147 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonReader reads objects from a JSON array or from a stream of objects
+// (JSON Lines), keeping the order of their keys.
+type jsonReader struct {
+	d       *json.Decoder
+	started bool
+	inArray bool
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return &jsonReader{d: d}
+}
+
+func (j *jsonReader) Read() (Record, error) {
+	if !j.started {
+		j.started = true
+		tok, err := j.d.Token()
+		if err != nil {
+			return Record{}, err
+		}
+		switch tok {
+		case json.Delim('['):
+			j.inArray = true
+		case json.Delim('{'):
+			return j.readObject()
+		default:
+			return Record{}, fmt.Errorf("expected an object or array, got %v", tok)
+		}
+	}
+
+	if j.inArray && !j.d.More() {
+		if _, err := j.d.Token(); err != nil { // ]
+			return Record{}, err
+		}
+		if _, err := j.d.Token(); err != io.EOF {
+			return Record{}, fmt.Errorf("unexpected data after the array")
+		}
+		return Record{}, io.EOF
+	}
+
+	tok, err := j.d.Token()
+	if err != nil {
+		return Record{}, err
+	}
+	if tok != json.Delim('{') {
+		return Record{}, fmt.Errorf("expected an object, got %v", tok)
+	}
+	return j.readObject()
+}
+
+// readObject reads the members of an object whose '{' has been consumed.
+func (j *jsonReader) readObject() (Record, error) {
+	var rec Record
+	for j.d.More() {
+		tok, err := j.d.Token()
+		if err != nil {
+			return Record{}, err
+		}
+		key := tok.(string)
+
+		var v interface{}
+		if err := j.d.Decode(&v); err != nil {
+			return Record{}, err
+		}
+		rec.Columns = append(rec.Columns, key)
+		rec.Values = append(rec.Values, v)
+	}
+	if _, err := j.d.Token(); err != nil { // }
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+type jsonWriter struct {
+	w     io.Writer
+	array bool
+	n     int
+	buf   bytes.Buffer
+}
+
+func newJSONWriter(w io.Writer, array bool) *jsonWriter {
+	return &jsonWriter{w: w, array: array}
+}
+
+func (j *jsonWriter) Write(r Record) error {
+	j.buf.Reset()
+	if j.array {
+		if j.n == 0 {
+			j.buf.WriteString("[\n")
+		} else {
+			j.buf.WriteString(",\n")
+		}
+	}
+	j.n++
+
+	j.buf.WriteByte('{')
+	for i, c := range r.Columns {
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+		k, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		j.buf.Write(k)
+		j.buf.WriteByte(':')
+
+		var v interface{}
+		if i < len(r.Values) {
+			v = r.Values[i]
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("column %q: %w", c, err)
+		}
+		j.buf.Write(b)
+	}
+	j.buf.WriteByte('}')
+	if !j.array {
+		j.buf.WriteByte('\n')
+	}
+
+	_, err := j.w.Write(j.buf.Bytes())
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.array {
+		return nil
+	}
+	end := "\n]\n"
+	if j.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
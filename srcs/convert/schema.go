@@ -0,0 +1,212 @@
+package convert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sampleReader buffers the first records of r so a schema can be derived
+// from them before anything is written.
+type sampleReader struct {
+	r       Reader
+	size    int
+	sampled bool
+	buf     []Record
+	err     error
+}
+
+func (s *sampleReader) sample() {
+	s.sampled = true
+	for len(s.buf) < s.size {
+		rec, err := s.r.Read()
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.buf = append(s.buf, rec)
+	}
+}
+
+func (s *sampleReader) next() (Record, error) {
+	if len(s.buf) > 0 {
+		rec := s.buf[0]
+		s.buf = s.buf[1:]
+		return rec, nil
+	}
+	if s.err != nil {
+		return Record{}, s.err
+	}
+	return s.r.Read()
+}
+
+func (s *sampleReader) header() ([]string, error) {
+	if h, ok := s.r.(headerReader); ok {
+		return h.header()
+	}
+	return nil, nil
+}
+
+type columnType int
+
+const (
+	unknownColumn columnType = iota // only empty cells so far
+	intColumn
+	floatColumn
+	boolColumn
+	stringColumn
+)
+
+// widen returns the narrowest type holding both t and the value s.
+func (t columnType) widen(s string) columnType {
+	if s == "" || t == stringColumn {
+		return t
+	}
+
+	var v columnType
+	switch {
+	case leadingZero(s):
+		return stringColumn
+	case isInt(s):
+		v = intColumn
+	case isFloat(s):
+		v = floatColumn
+	case s == "true" || s == "false":
+		v = boolColumn
+	default:
+		return stringColumn
+	}
+
+	switch {
+	case t == unknownColumn || t == v:
+		return v
+	case (t == intColumn && v == floatColumn) || (t == floatColumn && v == intColumn):
+		return floatColumn
+	}
+	return stringColumn
+}
+
+// leadingZero reports numbers such as zip codes, which are identifiers
+// rather than quantities.
+func leadingZero(s string) bool {
+	digits := strings.TrimPrefix(s, "-")
+	return len(digits) > 1 && digits[0] == '0' && digits[1] != '.'
+}
+
+func isInt(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// isFloat rejects the spellings of ParseFloat that are rarely meant as
+// numbers in a data file, such as "Inf", "NaN" and hexadecimal floats.
+func isFloat(s string) bool {
+	if strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) && r != 'e' && r != 'E' }) >= 0 {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// typedReader converts the string values of text input to the column types
+// inferred from the sample. Empty cells of typed columns become null.
+type typedReader struct {
+	sampleReader
+	types []columnType
+}
+
+func inferTypes(r Reader, size int) Reader {
+	return &typedReader{sampleReader: sampleReader{r: r, size: size}}
+}
+
+func (t *typedReader) Read() (Record, error) {
+	if !t.sampled {
+		t.sample()
+		for _, rec := range t.buf {
+			if t.types == nil {
+				t.types = make([]columnType, len(rec.Columns))
+			}
+			for i, v := range rec.Values {
+				if i < len(t.types) {
+					t.types[i] = t.types[i].widen(v.(string))
+				}
+			}
+		}
+	}
+
+	rec, err := t.next()
+	if err != nil {
+		return Record{}, err
+	}
+	for i, v := range rec.Values {
+		if i >= len(t.types) {
+			continue
+		}
+		if rec.Values[i], err = convertValue(v.(string), t.types[i]); err != nil {
+			return Record{}, fmt.Errorf("column %q: %w (inferred from the first %d records)", rec.Columns[i], err, t.size)
+		}
+	}
+	return rec, nil
+}
+
+func convertValue(s string, t columnType) (interface{}, error) {
+	if s == "" {
+		if t == stringColumn || t == unknownColumn {
+			return "", nil
+		}
+		return nil, nil
+	}
+
+	switch t {
+	case intColumn:
+		return strconv.ParseInt(s, 10, 64)
+	case floatColumn:
+		return strconv.ParseFloat(s, 64)
+	case boolColumn:
+		return strconv.ParseBool(s)
+	}
+	return s, nil
+}
+
+// columnReader gives every record of JSON input the same columns: the union
+// of the keys seen in the sample, in order of first appearance.
+type columnReader struct {
+	sampleReader
+	columns []string
+	index   map[string]int
+}
+
+func inferColumns(r Reader, size int) Reader {
+	return &columnReader{sampleReader: sampleReader{r: r, size: size}}
+}
+
+func (c *columnReader) Read() (Record, error) {
+	if !c.sampled {
+		c.sample()
+		c.index = make(map[string]int)
+		for _, rec := range c.buf {
+			for _, col := range rec.Columns {
+				if _, ok := c.index[col]; !ok {
+					c.index[col] = len(c.columns)
+					c.columns = append(c.columns, col)
+				}
+			}
+		}
+	}
+
+	rec, err := c.next()
+	if err != nil {
+		return Record{}, err
+	}
+
+	values := make([]interface{}, len(c.columns))
+	for i, col := range rec.Columns {
+		j, ok := c.index[col]
+		if !ok {
+			return Record{}, fmt.Errorf("column %q is not among the columns of the first %d records; raise the sample size", col, c.size)
+		}
+		values[j] = rec.Values[i]
+	}
+	return Record{Columns: c.columns, Values: values}, nil
+}
@@ -0,0 +1,120 @@
+package convert
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type textReader struct {
+	r       *csv.Reader
+	columns []string
+}
+
+func newTextReader(r io.Reader, comma rune) *textReader {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	if comma == '\t' {
+		cr.LazyQuotes = true
+	}
+	return &textReader{r: cr}
+}
+
+func (t *textReader) Read() (Record, error) {
+	if _, err := t.header(); err != nil {
+		return Record{}, err
+	}
+
+	row, err := t.r.Read()
+	if err != nil {
+		return Record{}, err
+	}
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		values[i] = v
+	}
+	return Record{Columns: t.columns, Values: values}, nil
+}
+
+func (t *textReader) header() ([]string, error) {
+	if t.columns == nil {
+		header, err := t.r.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.columns = header
+	}
+	return t.columns, nil
+}
+
+type textWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+	row         []string
+}
+
+func newTextWriter(w io.Writer, comma rune) *textWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &textWriter{w: cw}
+}
+
+func (t *textWriter) Write(r Record) error {
+	if !t.wroteHeader {
+		if err := t.writeHeader(r.Columns); err != nil {
+			return err
+		}
+	}
+	if len(r.Values) != len(t.row) {
+		return fmt.Errorf("%d values for %d columns", len(r.Values), len(t.row))
+	}
+
+	for i, v := range r.Values {
+		s, err := formatText(v)
+		if err != nil {
+			return fmt.Errorf("column %q: %w", r.Columns[i], err)
+		}
+		t.row[i] = s
+	}
+	return t.w.Write(t.row)
+}
+
+func (t *textWriter) writeHeader(columns []string) error {
+	if err := t.w.Write(columns); err != nil {
+		return err
+	}
+	t.wroteHeader = true
+	t.row = make([]string, len(columns))
+	return nil
+}
+
+func (t *textWriter) Close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// formatText renders a value in a single cell. Objects and arrays are
+// embedded as JSON.
+func formatText(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
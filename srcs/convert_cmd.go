@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"ex_04/convert"
+	"ex_04/csvcodec"
+)
+
+// runConvert implements `convert`, e.g.
+//
+//	go run . convert -in country.csv -out country.json
+//	go run . convert -from json -to jsonl -select url,origin -rename origin=ip < ip.json
+//	go run . convert -in ../appendix/ex_00/book.json -out book.csv
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	var (
+		in          = fs.String("in", "-", "input file, - for stdin")
+		out         = fs.String("out", "-", "output file, - for stdout")
+		from        = fs.String("from", "", "input format: csv, tsv, json or jsonl (default: extension of -in)")
+		to          = fs.String("to", "", "output format: csv, tsv, json or jsonl (default: extension of -out)")
+		selected    = fs.String("select", "", "comma separated columns to keep, in output order")
+		rename      = fs.String("rename", "", "comma separated old=new column names")
+		sample      = fs.Int("sample", convert.DefaultSampleSize, "records used to infer the schema")
+		noInference = fs.Bool("strings", false, "keep every CSV/TSV value as a string")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := convert.Options{SampleSize: *sample, NoInference: *noInference}
+	var err error
+	if opts.From, err = formatFlag("from", *from, *in); err != nil {
+		return err
+	}
+	if opts.To, err = formatFlag("to", *to, *out); err != nil {
+		return err
+	}
+	if *selected != "" {
+		opts.Select = strings.Split(*selected, ",")
+	}
+	if *rename != "" {
+		opts.Rename = make(map[string]string)
+		for _, pair := range strings.Split(*rename, ",") {
+			old, new, ok := strings.Cut(pair, "=")
+			if !ok || old == "" || new == "" {
+				return fmt.Errorf("-rename: %q is not old=new", pair)
+			}
+			opts.Rename[old] = new
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if *out == "-" {
+		return convert.Convert(r, os.Stdout, opts)
+	}
+	return csvcodec.WriteFileAtomic(*out, 0644, func(w io.Writer) error {
+		return convert.Convert(r, w, opts)
+	})
+}
+
+func formatFlag(name, value, path string) (convert.Format, error) {
+	if value == "" {
+		if path == "-" {
+			return "", fmt.Errorf("-%s is required when reading stdin or writing stdout", name)
+		}
+		value = path
+	}
+	f, err := convert.ParseFormat(value)
+	if err != nil {
+		return "", fmt.Errorf("-%s: %w", name, err)
+	}
+	return f, nil
+}
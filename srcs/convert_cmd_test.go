@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunConvertLeavesNoPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.csv")
+	out := filepath.Join(dir, "out.tsv")
+	if err := os.WriteFile(in, []byte("a,b\n1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runConvert([]string{"-in", in, "-out", out}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runConvert([]string{"-in", in, "-out", out, "-select", "missing"}); err == nil {
+		t.Fatal("runConvert() accepted an unknown column")
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a\tb\n1\t2\n" {
+		t.Errorf("output = %q, want the result of the first conversion", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files in %s, want in.csv and out.tsv", len(entries), dir)
+	}
+}
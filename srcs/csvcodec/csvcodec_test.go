@@ -0,0 +1,156 @@
+package csvcodec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type country struct {
+	Name       string    `csv:"country,required"`
+	ISO        string    `csv:"iso"`
+	Population int       `csv:"population"`
+	Density    *float64  `csv:"density"`
+	Member     bool      `csv:"member"`
+	Census     time.Time `csv:"census,format=2006-01-02"`
+	Note       string    `csv:"-"`
+}
+
+func TestDecoder(t *testing.T) {
+	in := "country,iso,population,density,member,census,extra\n" +
+		"usa,US/USA,310,34.5,true,2020-04-01,x\n" +
+		"japan,JP/JPN,127,,false,,y\n"
+
+	d := NewDecoder(strings.NewReader(in))
+	var got []country
+	for {
+		var c country
+		err := d.Decode(&c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, c)
+	}
+
+	density := 34.5
+	want := []country{
+		{Name: "usa", ISO: "US/USA", Population: 310, Density: &density, Member: true, Census: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "japan", ISO: "JP/JPN", Population: 127},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestDecoderParseError(t *testing.T) {
+	in := "country,iso,population\nusa,US/USA,310\nchina,CN/CHN,many\n"
+
+	var got []country
+	err := Unmarshal(strings.NewReader(in), &got)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Unmarshal() = %v, want a *ParseError", err)
+	}
+	if perr.Line != 3 || perr.Column != 14 || perr.Header != "population" || perr.Value != "many" {
+		t.Errorf("ParseError = %+v", perr)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("ParseError does not wrap the conversion error: %v", err)
+	}
+}
+
+func TestDecoderRequired(t *testing.T) {
+	var got []country
+	if err := Unmarshal(strings.NewReader("iso\nUS/USA\n"), &got); err == nil {
+		t.Error("missing required column was accepted")
+	}
+	if err := Unmarshal(strings.NewReader("country,iso\n,US/USA\n"), &got); err == nil {
+		t.Error("empty required cell was accepted")
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	type book struct {
+		Name string `csv:"Name"`
+		Year int    `csv:"year"`
+		Page int    `csv:"page"`
+	}
+	books := []book{
+		{Name: "Go lang web dev", Year: 2016, Page: 280},
+		{Name: "Go lang, thread", Year: 2018, Page: 256},
+	}
+
+	var buf bytes.Buffer
+	if err := Marshal(&buf, books); err != nil {
+		t.Fatal(err)
+	}
+	want := "Name,year,page\nGo lang web dev,2016,280\n\"Go lang, thread\",2018,256\n"
+	if buf.String() != want {
+		t.Errorf("Marshal() = %q, want %q", buf.String(), want)
+	}
+
+	var got []book
+	if err := Unmarshal(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, books) {
+		t.Errorf("round trip = %+v, want %+v", got, books)
+	}
+}
+
+func TestUpsertFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oreilly.csv")
+	// a file damaged by appending the header and rows on every run
+	damaged := "Name,year,page\nweb dev,2016,280\nthread,2018,256\nName,year,page\nweb dev,2016,280\n"
+	if err := os.WriteFile(path, []byte(damaged), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	header := []string{"page", "Name", "year"}
+	records := [][]string{
+		{"300", "thread", "2019"},
+		{"316", "interpreter", "2018"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := UpsertFile(path, header, "Name", records, SkipExisting); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertFile(t, path, "Name,year,page\nweb dev,2016,280\nthread,2018,256\ninterpreter,2018,316\n")
+
+	if err := UpsertFile(path, header, "Name", records, ReplaceExisting); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path, "Name,year,page\nweb dev,2016,280\nthread,2019,300\ninterpreter,2018,316\n")
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v; want the original 0600", info.Mode(), err)
+	}
+	if err := UpsertFile(path, []string{"Name", "year"}, "Name", nil, SkipExisting); err == nil {
+		t.Error("incompatible header was accepted")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*tmp*")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %q", matches)
+	}
+}
+
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), b, want)
+	}
+}
@@ -0,0 +1,170 @@
+package csvcodec
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// ParseError reports a cell that could not be converted to its field.
+// Line and Column are 1-based positions in the input.
+type ParseError struct {
+	Line   int
+	Column int
+	Header string
+	Value  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("csvcodec: line %d, column %d (%s): cannot parse %q: %v", e.Line, e.Column, e.Header, e.Value, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads one CSV record per Decode call, so inputs of any size can
+// be processed in constant memory. The first record is the header.
+type Decoder struct {
+	r      *csv.Reader
+	header []string
+
+	typ     reflect.Type
+	columns []int // field index of each column, -1 if unused
+	fields  []field
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	return &Decoder{r: cr}
+}
+
+// Reader gives access to the underlying csv.Reader, e.g. to change Comma
+// before the first call to Decode.
+func (d *Decoder) Reader() *csv.Reader {
+	return d.r
+}
+
+// Header reads the header record if needed and returns it.
+func (d *Decoder) Header() ([]string, error) {
+	if d.header != nil {
+		return d.header, nil
+	}
+	record, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	d.header = append([]string(nil), record...)
+	return d.header, nil
+}
+
+// Decode stores the next record in the struct pointed to by v. It returns
+// io.EOF when there are no more records.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("csvcodec: Decode needs a non-nil pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+
+	if _, err := d.Header(); err != nil {
+		return err
+	}
+	if d.typ != rv.Type() {
+		if err := d.bind(rv.Type()); err != nil {
+			return err
+		}
+	}
+
+	record, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+
+	for i, cell := range record {
+		if i >= len(d.columns) || d.columns[i] < 0 {
+			continue
+		}
+		f := d.fields[d.columns[i]]
+		if err := setValue(rv.FieldByIndex(f.index), cell, f.format); err != nil {
+			line, col := d.r.FieldPos(i)
+			return &ParseError{Line: line, Column: col, Header: d.header[i], Value: cell, Err: err}
+		}
+	}
+	for _, f := range d.fields {
+		if !f.required {
+			continue
+		}
+		i := d.columnOf(f)
+		if i >= len(record) || record[i] == "" {
+			line, _ := d.r.FieldPos(0)
+			return &ParseError{Line: line, Column: i + 1, Header: f.name, Err: fmt.Errorf("value is required")}
+		}
+	}
+	return nil
+}
+
+// bind maps the header columns to the fields of t. Exact names win over
+// case-insensitive matches.
+func (d *Decoder) bind(t reflect.Type) error {
+	fields, err := structFields(t)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]int, len(d.header))
+	for i, name := range d.header {
+		columns[i] = -1
+		for j, f := range fields {
+			if f.name == name {
+				columns[i] = j
+				break
+			}
+			if columns[i] < 0 && strings.EqualFold(f.name, strings.TrimSpace(name)) {
+				columns[i] = j
+			}
+		}
+	}
+
+	d.typ, d.fields, d.columns = t, fields, columns
+	for _, f := range fields {
+		if f.required && d.columnOf(f) < 0 {
+			return fmt.Errorf("csvcodec: required column %q is missing from the header", f.name)
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) columnOf(f field) int {
+	for i, j := range d.columns {
+		if j >= 0 && d.fields[j].name == f.name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Unmarshal decodes every record of r into the slice pointed to by v.
+func Unmarshal(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("csvcodec: Unmarshal needs a pointer to a slice, got %T", v)
+	}
+	slice := rv.Elem()
+	elem := slice.Type().Elem()
+
+	d := NewDecoder(r)
+	for {
+		item := reflect.New(elem)
+		if err := d.Decode(item.Interface()); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, item.Elem()))
+	}
+}
@@ -0,0 +1,108 @@
+package csvcodec
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Encoder writes structs as CSV records, preceded by a header generated
+// from the first struct.
+type Encoder struct {
+	w           *csv.Writer
+	typ         reflect.Type
+	fields      []field
+	wroteHeader bool
+	record      []string
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: csv.NewWriter(w)}
+}
+
+// Writer gives access to the underlying csv.Writer, e.g. to change Comma
+// before the first call to Encode.
+func (e *Encoder) Writer() *csv.Writer {
+	return e.w
+}
+
+// SkipHeader makes the Encoder write records only, for appending to a file
+// that already starts with the header.
+func (e *Encoder) SkipHeader() {
+	e.wroteHeader = true
+}
+
+// Encode writes v, a struct or pointer to struct. All values passed to one
+// Encoder must have the same type.
+func (e *Encoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("csvcodec: Encode needs a struct, got %T", v)
+	}
+
+	if e.typ == nil {
+		fields, err := structFields(rv.Type())
+		if err != nil {
+			return err
+		}
+		e.typ, e.fields = rv.Type(), fields
+		e.record = make([]string, len(fields))
+	} else if e.typ != rv.Type() {
+		return fmt.Errorf("csvcodec: Encode got %s after %s", rv.Type(), e.typ)
+	}
+
+	if !e.wroteHeader {
+		for i, f := range e.fields {
+			e.record[i] = f.name
+		}
+		if err := e.w.Write(e.record); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+
+	for i, f := range e.fields {
+		s, err := formatValue(rv.FieldByIndex(f.index), f.format)
+		if err != nil {
+			return fmt.Errorf("csvcodec: %s.%s: %w", e.typ, f.name, err)
+		}
+		e.record[i] = s
+	}
+	return e.w.Write(e.record)
+}
+
+// Flush writes buffered records to the underlying writer and reports any
+// error that occurred while writing.
+func (e *Encoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Marshal writes the header and every element of v, a slice of structs.
+func Marshal(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("csvcodec: Marshal needs a slice, got %T", v)
+	}
+
+	e := NewEncoder(w)
+	if rv.Len() == 0 {
+		header, err := Header(reflect.New(rv.Type().Elem()).Interface())
+		if err != nil {
+			return err
+		}
+		if err := e.w.Write(header); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := e.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return e.Flush()
+}
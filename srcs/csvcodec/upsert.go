@@ -0,0 +1,228 @@
+package csvcodec
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+type UpsertMode int
+
+const (
+	// SkipExisting keeps the rows already in the file.
+	SkipExisting UpsertMode = iota
+	// ReplaceExisting overwrites rows already in the file.
+	ReplaceExisting
+)
+
+// UpsertFile merges records into the CSV file at path, identifying rows by
+// the key column. The file is created with header when it does not exist;
+// otherwise its header must have the same columns, possibly in another
+// order, and records are rearranged to match it. Header lines repeated in
+// the body and rows with duplicate keys are dropped, so running the same
+// upsert twice leaves the file unchanged.
+//
+// The result is written to a temporary file that replaces path only once it
+// is complete, so a crash never leaves a half-written file behind.
+func UpsertFile(path string, header []string, key string, records [][]string, mode UpsertMode) error {
+	keyIndex := indexOf(header, key)
+	if keyIndex < 0 {
+		return fmt.Errorf("csvcodec: key column %q is not in the header", key)
+	}
+
+	existing, rows, perm, err := readCSVFile(path)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		existing = header
+	}
+
+	order, err := columnOrder(existing, header)
+	if err != nil {
+		return fmt.Errorf("csvcodec: %s: %w", path, err)
+	}
+	fileKey := order[keyIndex]
+
+	merged := make([][]string, 0, len(rows)+len(records))
+	index := make(map[string]int, len(rows)+len(records))
+	for _, row := range rows {
+		if equal(row, existing) {
+			continue
+		}
+		if fileKey >= len(row) {
+			return fmt.Errorf("csvcodec: %s: row %q has no %s column", path, row, key)
+		}
+		if _, ok := index[row[fileKey]]; ok {
+			continue
+		}
+		index[row[fileKey]] = len(merged)
+		merged = append(merged, row)
+	}
+
+	for _, record := range records {
+		if len(record) != len(header) {
+			return fmt.Errorf("csvcodec: record %q has %d fields, want %d", record, len(record), len(header))
+		}
+		row := make([]string, len(existing))
+		for i, v := range record {
+			row[order[i]] = v
+		}
+
+		i, ok := index[row[fileKey]]
+		switch {
+		case !ok:
+			index[row[fileKey]] = len(merged)
+			merged = append(merged, row)
+		case mode == ReplaceExisting:
+			merged[i] = row
+		}
+	}
+
+	return WriteFileAtomic(path, perm, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		cw.Write(existing)
+		cw.WriteAll(merged)
+		return cw.Error()
+	})
+}
+
+// Upsert is UpsertFile for a slice of structs; key is a column name as
+// given by the csv tags.
+func Upsert(path string, key string, v interface{}, mode UpsertMode) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("csvcodec: Upsert needs a slice, got %T", v)
+	}
+	elem := rv.Type().Elem()
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return fmt.Errorf("csvcodec: Upsert needs a slice of structs, got %T", v)
+	}
+	fields, err := structFields(elem)
+	if err != nil {
+		return err
+	}
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+
+	records := make([][]string, rv.Len())
+	for i := range records {
+		item := rv.Index(i)
+		for item.Kind() == reflect.Ptr {
+			item = item.Elem()
+		}
+		record := make([]string, len(fields))
+		for j, f := range fields {
+			if record[j], err = formatValue(item.FieldByIndex(f.index), f.format); err != nil {
+				return fmt.Errorf("csvcodec: %s.%s: %w", item.Type(), f.name, err)
+			}
+		}
+		records[i] = record
+	}
+	return UpsertFile(path, header, key, records, mode)
+}
+
+// readCSVFile returns a nil header when the file is missing or empty.
+func readCSVFile(path string) (header []string, rows [][]string, perm os.FileMode, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, 0644, nil
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("csvcodec: %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, nil, info.Mode().Perm(), nil
+	}
+	return records[0], records[1:], info.Mode().Perm(), nil
+}
+
+// columnOrder maps each column of header to its position in existing.
+func columnOrder(existing, header []string) ([]int, error) {
+	if len(existing) != len(header) {
+		return nil, fmt.Errorf("header %q does not match %q", existing, header)
+	}
+	order := make([]int, len(header))
+	for i, name := range header {
+		j := indexOf(existing, name)
+		if j < 0 {
+			return nil, fmt.Errorf("header %q has no column %q", existing, name)
+		}
+		order[i] = j
+	}
+	return order, nil
+}
+
+// WriteFileAtomic writes path through a temporary file in the same
+// directory, synced and then renamed over path, so readers see either the
+// old contents or all of the new ones and a failed write leaves path as it
+// was.
+func WriteFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func indexOf(s []string, v string) int {
+	for i := range s {
+		if s[i] == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
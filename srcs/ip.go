@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultIPURL answers with the {"origin": ..., "url": ...} payload that
+// ip.json keeps a copy of.
+const defaultIPURL = "https://httpbin.org/get"
+
+// IPClient fetches the caller's public address from an httpbin-style
+// endpoint, retrying server errors and network failures.
+type IPClient struct {
+	URL     string
+	Client  *http.Client
+	Backoff Backoff
+}
+
+func NewIPClient(url string) *IPClient {
+	if url == "" {
+		url = defaultIPURL
+	}
+	return &IPClient{
+		URL:     url,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		Backoff: defaultBackoff,
+	}
+}
+
+// Fetch returns an *HTTPError when the endpoint answers with a status other
+// than 200. Only 429 and 5xx responses are retried.
+func (c *IPClient) Fetch(ctx context.Context) (ip, error) {
+	var resp ip
+	err := c.Backoff.Retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+		if err != nil {
+			return permanent(err)
+		}
+		req.Header.Set("Accept", "application/json")
+
+		res, err := c.Client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return permanent(err)
+			}
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+			herr := &HTTPError{
+				StatusCode: res.StatusCode,
+				URL:        c.URL,
+				Detail:     strings.TrimSpace(string(b)),
+			}
+			if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+				return herr
+			}
+			return permanent(herr)
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			return permanent(fmt.Errorf("decode %s: %w", c.URL, err))
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// readIPFile decodes a saved response such as ip.json, for use offline.
+func readIPFile(path string) (ip, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ip{}, err
+	}
+	defer f.Close()
+
+	var resp ip
+	if err := json.NewDecoder(f).Decode(&resp); err != nil {
+		return ip{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return resp, nil
+}
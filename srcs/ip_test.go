@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestIPClient(h http.HandlerFunc) (*IPClient, func()) {
+	srv := httptest.NewServer(h)
+	c := NewIPClient(srv.URL + "/get")
+	c.Backoff = testBackoff
+	return c, srv.Close
+}
+
+func TestIPClientFetch(t *testing.T) {
+	var calls int32
+	c, done := newTestIPClient(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"origin": "192.0.2.1", "url": "https://httpbin.org/get"}`))
+	})
+	defer done()
+
+	got, err := c.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Origin != "192.0.2.1" || calls != 3 {
+		t.Errorf("Fetch() = %+v after %d calls", got, calls)
+	}
+}
+
+func TestIPClientHTTPError(t *testing.T) {
+	var calls int32
+	c, done := newTestIPClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "no such endpoint", http.StatusNotFound)
+	})
+	defer done()
+
+	_, err := c.Fetch(context.Background())
+	var herr *HTTPError
+	if !errors.As(err, &herr) {
+		t.Fatalf("Fetch() = %v, want an *HTTPError", err)
+	}
+	if herr.StatusCode != http.StatusNotFound || herr.URL != c.URL || herr.Detail != "no such endpoint" {
+		t.Errorf("HTTPError = %+v", herr)
+	}
+	if calls != 1 {
+		t.Errorf("a 404 was tried %d times", calls)
+	}
+}
+
+func TestIPClientCancel(t *testing.T) {
+	c, done := newTestIPClient(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Fetch(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch() = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestReadIPFile(t *testing.T) {
+	got, err := readIPFile("ip.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Origin == "" || got.URL == "" {
+		t.Errorf("readIPFile() = %+v", got)
+	}
+}
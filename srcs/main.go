@@ -2,36 +2,62 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	// "go/doc/comment"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"sync"
-
-	// "database/sql"
+	"time"
+	_ "time/tzdata" // Asia/Tokyo on hosts without a zoneinfo database
 
 	"github.com/gen2brain/beeep"
-	_ "github.com/jackc/pgx/v4/stdlib"
+
+	"ex_04/csvcodec"
+	"ex_04/repository"
 )
 
 type Warning interface {
 	Show(message string)
 }
 
+// LeveledWarning is a Warning that knows about severities and reports
+// delivery failures. Notifier prefers Notify over Show when a sink has it.
+type LeveledWarning interface {
+	Warning
+	Notify(ctx context.Context, level Severity, message string) error
+}
+
 type ConsoleWarning struct{}
 
 func (c ConsoleWarning) Show(message string) {
 	fmt.Fprintf(os.Stderr, "[%s]: %s\n", os.Args[0], message)
 }
 
+func (c ConsoleWarning) Notify(ctx context.Context, level Severity, message string) error {
+	_, err := fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", os.Args[0], level, message)
+	return err
+}
+
 type DesktopWarning struct{}
 
 func (d DesktopWarning) Show(message string) {
-	beeep.Alert(os.Args[0], message, "")
+	if err := beeep.Alert(os.Args[0], message, ""); err != nil {
+		log.Println(err)
+	}
+}
+
+// Notify raises an alert with sound for errors and above, and a silent
+// notification otherwise.
+func (d DesktopWarning) Notify(ctx context.Context, level Severity, message string) error {
+	title := fmt.Sprintf("%s: %s", os.Args[0], level)
+	if level >= SeverityError {
+		return beeep.Alert(title, message, "")
+	}
+	return beeep.Notify(title, message, "")
 }
 
 func interfaceTest() {
@@ -44,6 +70,28 @@ func interfaceTest() {
 	warn.Show("Hello World to desktop")
 }
 
+func notifierTest(configPath string) {
+	n := NewNotifier(
+		WithSink(Sink{Name: "console", Warning: ConsoleWarning{}, MinSeverity: SeverityInfo}),
+		WithSink(Sink{Name: "desktop", Warning: DesktopWarning{}, MinSeverity: SeverityError, RateLimit: 1, RatePeriod: time.Minute}),
+		WithDedupWindow(10*time.Second),
+	)
+	if configPath != "" {
+		var err error
+		n, err = LoadNotifier(configPath, WithDedupWindow(10*time.Second))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	for _, level := range []Severity{SeverityInfo, SeverityError, SeverityError} {
+		if err := n.Notify(ctx, level, "Hello World to every sink"); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
 func castTest() {
 	ctx := context.WithValue(context.Background(), "favorite", "zenigata")
 
@@ -77,25 +125,26 @@ func errorTest() {
 	fmt.Println(error)
 }
 
-type HTTPError struct {
-	StatusCode int
-	URL string
-}
-
 type ip struct {
 	Origin string `json:"origin"`
 	URL string `json:"url"`
 }
 
-func jsonTest() {
-	f, err := os.Open("ip.json")
-	if err != nil {
-		log.Fatal(err)
+func jsonTest(offline bool) {
+	if !offline {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		resp, err := NewIPClient("").Fetch(ctx)
+		if err == nil {
+			fmt.Printf("%+v\n", resp)
+			return
+		}
+		log.Printf("%v, falling back to ip.json", err)
 	}
-	defer f.Close()
 
-	var resp ip
-	if err := json.NewDecoder(f).Decode(&resp); err != nil {
+	resp, err := readIPFile("ip.json")
+	if err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("%+v\n", resp)
@@ -140,6 +189,12 @@ func omitEmptyTest() {
 	fmt.Println(string(b))
 }
 
+type country struct {
+	Name       string `csv:"country"`
+	ISO        string `csv:"iso"`
+	Population int    `csv:"population"`
+}
+
 func csvReaderTest () {
 	f, err := os.Open("country.csv")
 	if err != nil {
@@ -147,9 +202,10 @@ func csvReaderTest () {
 	}
 	defer f.Close()
 
-	r := csv.NewReader(f)
+	d := csvcodec.NewDecoder(f)
 	for {
-		record, err := r.Read()
+		var c country
+		err := d.Decode(&c)
 		if err == io.EOF {
 			break
 		}
@@ -157,111 +213,135 @@ func csvReaderTest () {
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println(record)
+		fmt.Printf("%+v\n", c)
 	}
 }
 
+type oreillyBook struct {
+	Name string `csv:"Name"`
+	Year int    `csv:"year"`
+	Page int    `csv:"page"`
+}
+
 func csvWriterTest() {
-	records := [][]string{
-		{"Name", "year", "page"},
-		{"Go lang web dev", "2016", "280"},
-		{"Go lang thread", "2018", "256"},
-		{"Go lang interpreter", "2018", "316"},
+	books := []oreillyBook{
+		{Name: "Go lang web dev", Year: 2016, Page: 280},
+		{Name: "Go lang thread", Year: 2018, Page: 256},
+		{Name: "Go lang interpreter", Year: 2018, Page: 316},
 	}
 
-	f, err := os.OpenFile("oreilly.csv", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err := csvcodec.Upsert("oreilly.csv", "Name", books, csvcodec.ReplaceExisting); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func dbTest(dsn string) {
+	ctx := context.Background()
+	db, err := repository.Open(ctx, repository.Config{DSN: dsn})
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer f.Close()
+	defer db.Close()
 
-	w := csv.NewWriter(f)
-	defer w.Flush()
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	book1 := repository.Book{
+		Title:      "Real world",
+		Author:     "Shibukawa",
+		Publisher:  "oreilly",
+		ReleasedAt: time.Date(2017, time.June, 14, 0, 0, 0, 0, jst),
+		ISBN:       "978-4-87311-786-0",
+	}
+	book2 := repository.Book{
+		Title:      "Go lang web dev",
+		Author:     "Chang",
+		Publisher:  "oreilly",
+		ReleasedAt: time.Date(2016, time.December, 1, 0, 0, 0, 0, jst),
+		ISBN:       "978-4-87311-797-6",
+	}
 
-	for _, record := range records {
-		if err := w.Write(record); err != nil {
-			log.Fatal(err)
+	books := []repository.Book{book1, book2}
+	err = db.WithTx(ctx, func(tx *repository.Tx) error {
+		for i, book := range books {
+			old, err := tx.Books.GetByISBN(ctx, book.ISBN)
+			switch {
+			case err == nil:
+				book.ID = old.ID
+				books[i], err = tx.Books.Update(ctx, book)
+			case errors.Is(err, repository.ErrNotFound):
+				books[i], err = tx.Books.Create(ctx, book)
+			}
+			if err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if err := w.Error(); err != nil {
-		log.Fatal(err)
+	for _, book := range books {
+		fmt.Println(book.ID) // book id is returned by the insert
 	}
 }
 
-// func dbTest() {
-// 	db, err := sql.Open("pgx", "host=localhost port=5432 user=testuser dbname=testdb password=pass sslmode=disable")
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-// 	err = db.Ping()
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-// 	books := []Book{book1, book2}
-// 	res, err := sql.NewInsert().Model(&books).Exec(ctx)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	for _, book := range books {
-// 		fmt.Println(book.ID) // book id is scanned automatically
-// 	}
-// }
-
-type Comment struct {
-	Message string
-	UserName string
+type HTTPConfig struct {
+	Addr  string
+	Store StoreConfig
 }
 
-func comments(w http.ResponseWriter, r *http.Request) {
-	var mutex = &sync.RWMutex{}
-	comments := make([]Comment, 0, 1000)
-
-	w.Header().Set("Content-Type", "application/json")
-
-	switch r.Method {
-	case http.MethodGet:
-		mutex.RLock()
+func httpTest(cfg HTTPConfig) {
+	store, err := NewCommentStore(cfg.Store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c, ok := store.(io.Closer); ok {
+		defer c.Close()
+	}
 
-		if err := json.NewEncoder(w).Encode(comments); err != nil {
-			http.Error(w, fmt.Sprintf(`{"status":"%s"}`, err), http.StatusInternalServerError)
-		}
-		mutex.RUnlock()
+	h := comments(store)
+	http.Handle("/comments", h)
+	http.Handle("/comments/", h)
+	log.Printf("Start listening at %s (store: %s)", cfg.Addr, cfg.Store.Backend)
+	if err := http.ListenAndServe(cfg.Addr, nil); err != nil {
+		log.Println(err)
+	}
+}
 
-	case http.MethodPost:
-		var c Comment
-		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-			http.Error(w, fmt.Sprintf(`{"status":"%s"}`, err), http.StatusInternalServerError)
-			return
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		if err := runConvert(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "convert:", err)
+			os.Exit(1)
 		}
-		mutex.Lock()
-		comments = append(comments, c)
-		mutex.Unlock()
-
-		w.WriteHeader(http.StatusCreated)
-		w.Write([]byte(`{"status": "created"}`))
-	
-	default:
-		http.Error(w, `{"status":"permits only GET or POST"}`, http.StatusMethodNotAllowed)
+		return
 	}
-}
 
-func httpTest() {
-	http.HandleFunc("/comments", comments)
-	http.ListenAndServe(":8888", nil)
-}
+	var cfg HTTPConfig
+	flag.StringVar(&cfg.Addr, "addr", ":8888", "listen address of the comments server")
+	flag.StringVar(&cfg.Store.Backend, "store", "memory", "comment store: memory, file or postgres")
+	flag.StringVar(&cfg.Store.Path, "store-path", "comments.jsonl", "path of the file comment store")
+	flag.StringVar(&cfg.Store.DSN, "dsn", "host=localhost port=5432 user=testuser dbname=testdb password=pass sslmode=disable", "data source name of the postgres comment store")
+	withDB := flag.Bool("db", false, "run the postgres demo against -dsn")
+	warningsConfig := flag.String("warnings", "", "JSON file configuring the warning sinks")
+	offline := flag.Bool("offline", false, "read ip.json instead of calling httpbin")
+	flag.Parse()
 
-func main() {
 	interfaceTest()
+	notifierTest(*warningsConfig)
 	castTest()
 	errorTest()
-	jsonTest()
+	jsonTest(*offline)
 	jsonSliceTest()
 	omitEmptyTest()
 	csvReaderTest()
 	csvWriterTest()
 	// docker run -d --name my-postgres -e POSTGRES_USER=testuser -e POSTGRES_PASSWORD=pass -e POSTGRES_DB=testdb -p 5432:5432 postgres
-	// dbTest()
-	httpTest()
+	if *withDB {
+		dbTest(cfg.Store.DSN)
+	}
+	httpTest(cfg)
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota + 1
+	SeverityWarn
+	SeverityError
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityWarn:     "warn",
+	SeverityError:    "error",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+func ParseSeverity(name string) (Severity, error) {
+	for s, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity: %q", name)
+}
+
+// Sink is one destination of a Notifier. Warnings below MinSeverity are not
+// sent to it, and when RateLimit is set at most RateLimit warnings are sent
+// per RatePeriod; the rest are dropped.
+type Sink struct {
+	Name        string
+	Warning     Warning
+	MinSeverity Severity
+	RateLimit   int
+	RatePeriod  time.Duration
+
+	limiter *tokenBucket
+}
+
+// SinkError reports the failure of a single sink.
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Sink, e.Err)
+}
+
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// NotifyError collects the sinks that failed to deliver one warning.
+type NotifyError struct {
+	Errors []*SinkError
+}
+
+func (e *NotifyError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return "notify: " + strings.Join(msgs, "; ")
+}
+
+// Notifier fans a warning out to all of its sinks concurrently.
+type Notifier struct {
+	sinks []*Sink
+	dedup time.Duration
+	now   func() time.Time
+
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+type NotifierOption func(n *Notifier)
+
+func WithSink(s Sink) NotifierOption {
+	return func(n *Notifier) { n.sinks = append(n.sinks, &s) }
+}
+
+// WithDedupWindow drops a warning when the same severity and message has
+// already been sent within d.
+func WithDedupWindow(d time.Duration) NotifierOption {
+	return func(n *Notifier) { n.dedup = d }
+}
+
+func WithClock(now func() time.Time) NotifierOption {
+	return func(n *Notifier) { n.now = now }
+}
+
+func NewNotifier(opts ...NotifierOption) *Notifier {
+	n := &Notifier{
+		now:      time.Now,
+		lastSeen: make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	for _, s := range n.sinks {
+		if s.RateLimit > 0 {
+			s.limiter = newTokenBucket(s.RateLimit, s.RatePeriod, n.now())
+		}
+	}
+	return n
+}
+
+// Notify sends message to every sink accepting level and waits for them.
+// It returns a *NotifyError when one or more sinks fail.
+func (n *Notifier) Notify(ctx context.Context, level Severity, message string) error {
+	now := n.now()
+	if n.duplicate(level, message, now) {
+		return nil
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		nerr  NotifyError
+	)
+	for _, s := range n.sinks {
+		if level < s.MinSeverity {
+			continue
+		}
+		if s.limiter != nil && !s.limiter.take(now) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(s *Sink) {
+			defer wg.Done()
+			if err := deliver(ctx, s.Warning, level, message); err != nil {
+				mutex.Lock()
+				nerr.Errors = append(nerr.Errors, &SinkError{Sink: s.Name, Err: err})
+				mutex.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if len(nerr.Errors) > 0 {
+		return &nerr
+	}
+	return nil
+}
+
+func deliver(ctx context.Context, w Warning, level Severity, message string) (err error) {
+	// a panicking sink must not take the others down with it
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	if lw, ok := w.(LeveledWarning); ok {
+		return lw.Notify(ctx, level, message)
+	}
+	w.Show(message)
+	return nil
+}
+
+func (n *Notifier) duplicate(level Severity, message string, now time.Time) bool {
+	if n.dedup <= 0 {
+		return false
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for k, t := range n.lastSeen {
+		if now.Sub(t) >= n.dedup {
+			delete(n.lastSeen, k)
+		}
+	}
+
+	key := level.String() + "\x00" + message
+	if _, ok := n.lastSeen[key]; ok {
+		return true
+	}
+	n.lastSeen[key] = now
+	return false
+}
+
+// tokenBucket allows burst events at once and refills at burst per period.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	burst  float64
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(burst int, period time.Duration, now time.Time) *tokenBucket {
+	if period <= 0 {
+		period = time.Second
+	}
+	return &tokenBucket{
+		burst:  float64(burst),
+		rate:   float64(burst) / period.Seconds(),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordWarning struct {
+	mutex    sync.Mutex
+	messages []string
+	err      error
+}
+
+func (r *recordWarning) Show(message string) {
+	r.Notify(context.Background(), SeverityWarn, message)
+}
+
+func (r *recordWarning) Notify(ctx context.Context, level Severity, message string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.messages = append(r.messages, level.String()+":"+message)
+	return r.err
+}
+
+func (r *recordWarning) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.messages)
+}
+
+func TestNotifier(t *testing.T) {
+	now := time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC)
+	errBroken := errors.New("broken")
+
+	all := &recordWarning{}
+	critical := &recordWarning{}
+	limited := &recordWarning{}
+	broken := &recordWarning{err: errBroken}
+
+	n := NewNotifier(
+		WithSink(Sink{Name: "all", Warning: all}),
+		WithSink(Sink{Name: "critical", Warning: critical, MinSeverity: SeverityCritical}),
+		WithSink(Sink{Name: "limited", Warning: limited, RateLimit: 2, RatePeriod: time.Minute}),
+		WithSink(Sink{Name: "broken", Warning: broken, MinSeverity: SeverityError}),
+		WithDedupWindow(time.Minute),
+		WithClock(func() time.Time { return now }),
+	)
+	ctx := context.Background()
+
+	for _, msg := range []string{"a", "b", "c", "a"} {
+		if err := n.Notify(ctx, SeverityInfo, msg); err != nil {
+			t.Fatalf("Notify(info, %s) = %v", msg, err)
+		}
+	}
+	// "a" is a duplicate, "c" is over the rate limit of the limited sink
+	if all.count() != 3 || limited.count() != 2 || critical.count() != 0 {
+		t.Errorf("counts = all %d, limited %d, critical %d; want 3, 2, 0", all.count(), limited.count(), critical.count())
+	}
+
+	err := n.Notify(ctx, SeverityCritical, "down")
+	var nerr *NotifyError
+	if !errors.As(err, &nerr) || len(nerr.Errors) != 1 || nerr.Errors[0].Sink != "broken" || !errors.Is(nerr.Errors[0], errBroken) {
+		t.Errorf("Notify(critical) = %v, want an error from the broken sink", err)
+	}
+	if critical.count() != 1 {
+		t.Errorf("critical sink got %d warnings, want 1", critical.count())
+	}
+
+	now = now.Add(time.Minute)
+	if err := n.Notify(ctx, SeverityInfo, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if all.count() != 5 || limited.count() != 3 {
+		t.Errorf("after the windows passed: all %d, limited %d; want 5, 3", all.count(), limited.count())
+	}
+}
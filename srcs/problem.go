@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HTTPError describes a failed HTTP exchange. It is rendered to clients as
+// an RFC 7807 problem document, where URL becomes the "instance" member.
+type HTTPError struct {
+	StatusCode int
+	URL        string
+	Detail     string
+	Errors     []FieldError
+}
+
+// FieldError points at a single invalid member of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.URL != "" {
+		msg += ": " + e.URL
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, fe := range e.Errors {
+		msg += fmt.Sprintf("; %s %s", fe.Field, fe.Message)
+	}
+	return msg
+}
+
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// writeProblem renders err as application/problem+json. Errors that are not
+// an *HTTPError or a known store error are logged and reported as a bare 500
+// so internal details do not leak to clients.
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var he *HTTPError
+	switch {
+	case errors.As(err, &he):
+	case errors.Is(err, ErrNotFound):
+		he = &HTTPError{StatusCode: http.StatusNotFound, Detail: err.Error()}
+	case errors.Is(err, ErrConflict):
+		he = &HTTPError{StatusCode: http.StatusConflict, Detail: err.Error()}
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		he = &HTTPError{StatusCode: http.StatusInternalServerError}
+	}
+
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(he.StatusCode),
+		Status:   he.StatusCode,
+		Detail:   he.Detail,
+		Instance: he.URL,
+		Errors:   he.Errors,
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(he.StatusCode)
+	json.NewEncoder(w).Encode(p)
+}
+
+const maxBodyBytes = 64 << 10
+
+// decodeJSON reads exactly one JSON value from the request body into v,
+// rejecting unknown fields and bodies larger than maxBodyBytes.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	d.DisallowUnknownFields()
+
+	err := d.Decode(v)
+	if err == nil {
+		if _, err := d.Token(); err != io.EOF {
+			return badRequest("request body must contain a single JSON value")
+		}
+		return nil
+	}
+
+	var (
+		maxBytesErr *http.MaxBytesError
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &HTTPError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Detail:     fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit),
+		}
+	case errors.As(err, &syntaxErr):
+		return badRequest(fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequest("request body must be a JSON object")
+	case errors.As(err, &typeErr):
+		return badRequest("request body has invalid fields",
+			FieldError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return badRequest("request body has unknown fields", FieldError{Field: field, Message: "is not allowed"})
+	}
+	return badRequest(err.Error())
+}
+
+func badRequest(detail string, errs ...FieldError) *HTTPError {
+	return &HTTPError{StatusCode: http.StatusBadRequest, Detail: detail, Errors: errs}
+}
+
+// invalid returns nil when there is nothing to report, so it can wrap the
+// result of a Validate method directly.
+func invalid(errs ...FieldError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &HTTPError{
+		StatusCode: http.StatusUnprocessableEntity,
+		Detail:     "request body has invalid fields",
+		Errors:     errs,
+	}
+}
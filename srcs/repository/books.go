@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type Book struct {
+	ID         int64
+	Title      string
+	Author     string
+	Publisher  string
+	ReleasedAt time.Time
+	ISBN       string
+}
+
+type BookRepository struct {
+	q querier
+}
+
+const bookColumns = `id, title, author, publisher, released_at, isbn`
+
+func scanBook(row scanner) (Book, error) {
+	var b Book
+	err := row.Scan(&b.ID, &b.Title, &b.Author, &b.Publisher, &b.ReleasedAt, &b.ISBN)
+	return b, notFound(err)
+}
+
+func (r *BookRepository) List(ctx context.Context) ([]Book, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT `+bookColumns+` FROM books ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := make([]Book, 0)
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, b)
+	}
+	return books, rows.Err()
+}
+
+func (r *BookRepository) Get(ctx context.Context, id int64) (Book, error) {
+	row := r.q.QueryRowContext(ctx, `SELECT `+bookColumns+` FROM books WHERE id = $1`, id)
+	return scanBook(row)
+}
+
+func (r *BookRepository) GetByISBN(ctx context.Context, isbn string) (Book, error) {
+	row := r.q.QueryRowContext(ctx, `SELECT `+bookColumns+` FROM books WHERE isbn = $1`, isbn)
+	return scanBook(row)
+}
+
+// Create inserts b and returns it with the ID assigned by the database.
+func (r *BookRepository) Create(ctx context.Context, b Book) (Book, error) {
+	row := r.q.QueryRowContext(ctx, `INSERT INTO books (title, author, publisher, released_at, isbn)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+bookColumns, b.Title, b.Author, b.Publisher, b.ReleasedAt, b.ISBN)
+	return scanBook(row)
+}
+
+func (r *BookRepository) Update(ctx context.Context, b Book) (Book, error) {
+	row := r.q.QueryRowContext(ctx, `UPDATE books
+		SET title = $2, author = $3, publisher = $4, released_at = $5, isbn = $6
+		WHERE id = $1
+		RETURNING `+bookColumns, b.ID, b.Title, b.Author, b.Publisher, b.ReleasedAt, b.ISBN)
+	return scanBook(row)
+}
+
+func (r *BookRepository) Delete(ctx context.Context, id int64) error {
+	res, err := r.q.ExecContext(ctx, `DELETE FROM books WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
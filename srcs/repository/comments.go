@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type Comment struct {
+	ID        int64
+	Message   string
+	UserName  string
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CommentFilter selects at most Limit comments with an ID greater than
+// AfterID, in ascending ID order. Zero values disable a condition.
+type CommentFilter struct {
+	UserName string
+	AfterID  int64
+	Limit    int
+}
+
+type CommentRepository struct {
+	q querier
+}
+
+const commentColumns = `id, message, user_name, version, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanComment(row scanner) (Comment, error) {
+	var c Comment
+	err := row.Scan(&c.ID, &c.Message, &c.UserName, &c.Version, &c.CreatedAt, &c.UpdatedAt)
+	return c, notFound(err)
+}
+
+func (r *CommentRepository) List(ctx context.Context, f CommentFilter) ([]Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments
+		WHERE id > $1 AND ($2 = '' OR user_name = $2)
+		ORDER BY id`
+	args := []interface{}{f.AfterID, f.UserName}
+	if f.Limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := r.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]Comment, 0)
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (r *CommentRepository) Get(ctx context.Context, id int64) (Comment, error) {
+	row := r.q.QueryRowContext(ctx, `SELECT `+commentColumns+` FROM comments WHERE id = $1`, id)
+	return scanComment(row)
+}
+
+// Create inserts c and returns it with the ID, version and timestamps
+// assigned by the database.
+func (r *CommentRepository) Create(ctx context.Context, c Comment) (Comment, error) {
+	row := r.q.QueryRowContext(ctx, `INSERT INTO comments (message, user_name) VALUES ($1, $2)
+		RETURNING `+commentColumns, c.Message, c.UserName)
+	return scanComment(row)
+}
+
+// Update overwrites the message and user name of c.ID. A non-zero c.Version
+// must match the stored version, otherwise ErrConflict is returned.
+func (r *CommentRepository) Update(ctx context.Context, c Comment) (Comment, error) {
+	row := r.q.QueryRowContext(ctx, `UPDATE comments
+		SET message = $2, user_name = $3, version = version + 1, updated_at = now()
+		WHERE id = $1 AND ($4 = 0 OR version = $4)
+		RETURNING `+commentColumns, c.ID, c.Message, c.UserName, c.Version)
+	updated, err := scanComment(row)
+	if errors.Is(err, ErrNotFound) {
+		// tell a missing comment apart from a stale version
+		if _, err := r.Get(ctx, c.ID); err != nil {
+			return Comment{}, err
+		}
+		return Comment{}, ErrConflict
+	}
+	return updated, err
+}
+
+func (r *CommentRepository) Delete(ctx context.Context, id int64) error {
+	res, err := r.q.ExecContext(ctx, `DELETE FROM comments WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
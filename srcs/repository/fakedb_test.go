@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeStore is an in-memory stand-in for the Postgres database, so the
+// repository tests run without one. It understands exactly the statements
+// this package sends and fails on anything else, so a changed query shows
+// up here as an error rather than as a test that passes by accident.
+//
+// A transaction snapshots the tables when it begins and restores them on
+// rollback, which is enough for the sequential tests but gives no
+// isolation between concurrent transactions.
+type fakeStore struct {
+	mu         sync.Mutex
+	migrations map[int64]string
+	books      table
+	comments   table
+	nextID     int64
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		migrations: map[int64]string{},
+		books:      table{},
+		comments:   table{},
+	}
+}
+
+// openFakeDB returns a DB backed by a new, empty fakeStore.
+func openFakeDB(ctx context.Context) (*DB, error) {
+	return newDB(ctx, sql.OpenDB(fakeConnector{newFakeStore()}))
+}
+
+type fakeConnector struct {
+	store *fakeStore
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{store: c.store}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakedb: use sql.OpenDB with a fakeConnector")
+}
+
+type fakeConn struct {
+	store *fakeStore
+	tx    *fakeTx
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("fakedb: transaction already open")
+	}
+	s := c.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.tx = &fakeTx{
+		conn:       c,
+		migrations: copyMap(s.migrations),
+		books:      copyRows(s.books),
+		comments:   copyRows(s.comments),
+		nextID:     s.nextID,
+	}
+	return c.tx, nil
+}
+
+type fakeTx struct {
+	conn       *fakeConn
+	migrations map[int64]string
+	books      table
+	comments   table
+	nextID     int64
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	s := tx.conn.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.migrations, s.books, s.comments, s.nextID = tx.migrations, tx.books, tx.comments, tx.nextID
+	tx.conn.tx = nil
+	return nil
+}
+
+func copyMap(m map[int64]string) map[int64]string {
+	c := make(map[int64]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func copyRows(t table) table {
+	c := make(table, len(t))
+	for k, v := range t {
+		c[k] = append([]driver.Value(nil), v...)
+	}
+	return c
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.store.run(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows.values)), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.store.run(query, values(args))
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, a := range args {
+		v[i] = a.Value
+	}
+	return v
+}
+
+var (
+	bookColumnNames    = strings.Split(strings.ReplaceAll(bookColumns, " ", ""), ",")
+	commentColumnNames = strings.Split(strings.ReplaceAll(commentColumns, " ", ""), ",")
+)
+
+// run executes one statement and returns the rows it selects, returns or,
+// for DELETE, removes.
+func (s *fakeStore) run(query string, args []driver.Value) (*fakeRows, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(q, "SELECT pg_advisory_"),
+		strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		return &fakeRows{}, nil
+	case strings.HasPrefix(q, "--"), strings.HasPrefix(q, "CREATE TABLE books"):
+		// the embedded migrations; the tables above always exist
+		return &fakeRows{}, nil
+	case q == "SELECT COALESCE(MAX(version), 0) FROM schema_migrations":
+		var current int64
+		for v := range s.migrations {
+			if v > current {
+				current = v
+			}
+		}
+		return &fakeRows{columns: []string{"coalesce"}, values: [][]driver.Value{{current}}}, nil
+	case strings.HasPrefix(q, "INSERT INTO schema_migrations"):
+		s.migrations[args[0].(int64)] = args[1].(string)
+		return &fakeRows{values: [][]driver.Value{nil}}, nil
+
+	case q == "SELECT "+bookColumns+" FROM books ORDER BY id":
+		return s.books.selectRows(bookColumnNames, func([]driver.Value) bool { return true }), nil
+	case q == "SELECT "+bookColumns+" FROM books WHERE id = $1":
+		return s.books.selectRows(bookColumnNames, func(r []driver.Value) bool { return r[0] == args[0] }), nil
+	case q == "SELECT "+bookColumns+" FROM books WHERE isbn = $1":
+		return s.books.selectRows(bookColumnNames, func(r []driver.Value) bool { return r[5] == args[0] }), nil
+	case strings.HasPrefix(q, "INSERT INTO books "):
+		if err := s.uniqueISBN(0, args[4]); err != nil {
+			return nil, err
+		}
+		s.nextID++
+		row := append([]driver.Value{s.nextID}, args...)
+		s.books[s.nextID] = row
+		return &fakeRows{columns: bookColumnNames, values: [][]driver.Value{row}}, nil
+	case strings.HasPrefix(q, "UPDATE books "):
+		id := args[0].(int64)
+		if _, ok := s.books[id]; !ok {
+			return &fakeRows{columns: bookColumnNames}, nil
+		}
+		if err := s.uniqueISBN(id, args[5]); err != nil {
+			return nil, err
+		}
+		s.books[id] = append([]driver.Value(nil), args...)
+		return &fakeRows{columns: bookColumnNames, values: [][]driver.Value{s.books[id]}}, nil
+	case q == "DELETE FROM books WHERE id = $1":
+		return s.books.delete(args[0].(int64)), nil
+
+	case strings.HasPrefix(q, "SELECT "+commentColumns+" FROM comments WHERE id > $1 AND ($2 = '' OR user_name = $2) ORDER BY id"):
+		rows := s.comments.selectRows(commentColumnNames, func(r []driver.Value) bool {
+			return r[0].(int64) > args[0].(int64) && (args[1] == "" || r[2] == args[1])
+		})
+		if strings.HasSuffix(q, " LIMIT $3") && int64(len(rows.values)) > args[2].(int64) {
+			rows.values = rows.values[:args[2].(int64)]
+		}
+		return rows, nil
+	case q == "SELECT "+commentColumns+" FROM comments WHERE id = $1":
+		return s.comments.selectRows(commentColumnNames, func(r []driver.Value) bool { return r[0] == args[0] }), nil
+	case strings.HasPrefix(q, "INSERT INTO comments "):
+		s.nextID++
+		now := time.Now()
+		row := []driver.Value{s.nextID, args[0], args[1], int64(1), now, now}
+		s.comments[s.nextID] = row
+		return &fakeRows{columns: commentColumnNames, values: [][]driver.Value{row}}, nil
+	case strings.HasPrefix(q, "UPDATE comments "):
+		row, ok := s.comments[args[0].(int64)]
+		if !ok || args[3].(int64) != 0 && row[3] != args[3] {
+			return &fakeRows{columns: commentColumnNames}, nil
+		}
+		row = []driver.Value{row[0], args[1], args[2], row[3].(int64) + 1, row[4], time.Now()}
+		s.comments[args[0].(int64)] = row
+		return &fakeRows{columns: commentColumnNames, values: [][]driver.Value{row}}, nil
+	case q == "DELETE FROM comments WHERE id = $1":
+		return s.comments.delete(args[0].(int64)), nil
+	}
+	return nil, fmt.Errorf("fakedb: unsupported statement %q", q)
+}
+
+func (s *fakeStore) uniqueISBN(id int64, isbn driver.Value) error {
+	for other, r := range s.books {
+		if other != id && r[5] == isbn {
+			return fmt.Errorf("fakedb: duplicate key value violates unique constraint on isbn %v", isbn)
+		}
+	}
+	return nil
+}
+
+// table holds rows by ID, in column order.
+type table map[int64][]driver.Value
+
+func (t table) selectRows(columns []string, match func([]driver.Value) bool) *fakeRows {
+	ids := make([]int64, 0, len(t))
+	for id := range t {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	rows := &fakeRows{columns: columns}
+	for _, id := range ids {
+		if match(t[id]) {
+			rows.values = append(rows.values, t[id])
+		}
+	}
+	return rows
+}
+
+func (t table) delete(id int64) *fakeRows {
+	row, ok := t[id]
+	if !ok {
+		return &fakeRows{}
+	}
+	delete(t, id)
+	return &fakeRows{values: [][]driver.Value{row}}
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
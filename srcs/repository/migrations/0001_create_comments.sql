@@ -0,0 +1,14 @@
+-- comments used to be created by the server itself, so upgrade that table
+-- in place instead of failing on it.
+CREATE TABLE IF NOT EXISTS comments (
+	id BIGSERIAL PRIMARY KEY,
+	message TEXT NOT NULL,
+	user_name TEXT NOT NULL
+);
+
+ALTER TABLE comments
+	ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1,
+	ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS comments_user_name_id_idx ON comments (user_name, id);
@@ -0,0 +1,8 @@
+CREATE TABLE books (
+	id BIGSERIAL PRIMARY KEY,
+	title TEXT NOT NULL,
+	author TEXT NOT NULL,
+	publisher TEXT NOT NULL,
+	released_at TIMESTAMPTZ NOT NULL,
+	isbn TEXT NOT NULL UNIQUE
+);
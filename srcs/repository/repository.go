@@ -0,0 +1,138 @@
+// Package repository is the Postgres data layer of the srcs module.
+//
+// Open connects through the pgx stdlib driver and brings the schema up to
+// date with the migrations embedded in this package before returning.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record has been modified by another transaction")
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type DB struct {
+	db       *sql.DB
+	Comments *CommentRepository
+	Books    *BookRepository
+}
+
+// Tx exposes the same repositories as DB, bound to one transaction.
+type Tx struct {
+	tx       *sql.Tx
+	Comments *CommentRepository
+	Books    *BookRepository
+}
+
+// Config configures Open. A pool setting left at zero keeps the
+// database/sql default for it.
+type Config struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func (cfg Config) apply(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+}
+
+func Open(ctx context.Context, cfg Config) (*DB, error) {
+	db, err := sql.Open("pgx", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	cfg.apply(db)
+	return newDB(ctx, db)
+}
+
+// newDB checks and migrates db, which it closes on failure.
+func newDB(ctx context.Context, db *sql.DB) (*DB, error) {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return &DB{
+		db:       db,
+		Comments: &CommentRepository{q: db},
+		Books:    &BookRepository{q: db},
+	}, nil
+}
+
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// WithTx runs fn in a transaction, committing when fn returns nil and
+// rolling back otherwise, including when fn panics.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			sqlTx.Rollback()
+		}
+	}()
+
+	tx := &Tx{
+		tx:       sqlTx,
+		Comments: &CommentRepository{q: sqlTx},
+		Books:    &BookRepository{q: sqlTx},
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migrations[%d] = %s, want version %d", i, m.name, i+1)
+		}
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{name: "no version", fsys: fstest.MapFS{"migrations/create.sql": {}}},
+		{name: "bad version", fsys: fstest.MapFS{"migrations/x1_create.sql": {}}},
+		{name: "duplicate", fsys: fstest.MapFS{
+			"migrations/0001_a.sql": {},
+			"migrations/1_b.sql":    {},
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := loadMigrations(tt.fsys); err == nil {
+			t.Errorf("%s: loadMigrations() succeeded, want an error", tt.name)
+		}
+	}
+}
+
+// openTestDB connects to the database named by REPOSITORY_TEST_DSN, e.g. the
+// container started with the docker command in srcs/main.go, and to an
+// in-memory fake otherwise.
+func openTestDB(t *testing.T) *DB {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var db *DB
+	var err error
+	if dsn := os.Getenv("REPOSITORY_TEST_DSN"); dsn != "" {
+		db, err = Open(ctx, Config{DSN: dsn})
+	} else {
+		db, err = openFakeDB(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// running the migrations again must be a no-op
+	if err := Migrate(ctx, db.db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestConfigKeepsIdleConns(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := sql.OpenDB(fakeConnector{newFakeStore()})
+	Config{}.apply(sqlDB)
+	db, err := newDB(ctx, sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Books.List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.db.Stats(); stats.Idle != 1 || stats.OpenConnections != 1 {
+		t.Errorf("Stats() = %d open, %d idle, want 1 open and idle", stats.OpenConnections, stats.Idle)
+	}
+}
+
+func TestComments(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	c, err := db.Comments.Create(ctx, Comment{Message: "hello", UserName: "repository-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Comments.Delete(ctx, c.ID) })
+
+	c.Message = "edited"
+	updated, err := db.Comments.Update(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != c.Version+1 {
+		t.Errorf("Update() version = %d, want %d", updated.Version, c.Version+1)
+	}
+	if _, err := db.Comments.Update(ctx, c); !errors.Is(err, ErrConflict) {
+		t.Errorf("stale Update() error = %v, want ErrConflict", err)
+	}
+
+	got, err := db.Comments.List(ctx, CommentFilter{UserName: "repository-test", AfterID: c.ID - 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Message != "edited" {
+		t.Errorf("List() = %+v", got)
+	}
+
+	if err := db.Comments.Delete(ctx, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Comments.Get(ctx, c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(deleted) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBooks(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	isbn := "repository-test-" + time.Now().Format(time.RFC3339Nano)
+	released := time.Date(2017, time.June, 14, 0, 0, 0, 0, time.UTC)
+	b, err := db.Books.Create(ctx, Book{Title: "t", Author: "a", Publisher: "p", ReleasedAt: released, ISBN: isbn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Books.Delete(ctx, b.ID) })
+
+	if _, err := db.Books.Create(ctx, Book{Title: "t2", Author: "a", Publisher: "p", ReleasedAt: released, ISBN: isbn}); err == nil {
+		t.Error("Create() with a duplicate ISBN succeeded")
+	}
+
+	b.Title = "edited"
+	if _, err := db.Books.Update(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Books.GetByISBN(ctx, isbn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != b.ID || got.Title != "edited" || !got.ReleasedAt.Equal(released) {
+		t.Errorf("GetByISBN() = %+v, want %+v", got, b)
+	}
+
+	list, err := db.Books.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, l := range list {
+		found = found || l.ID == b.ID
+	}
+	if !found {
+		t.Errorf("List() = %+v, missing %d", list, b.ID)
+	}
+
+	if err := db.Books.Delete(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Books.Delete(ctx, b.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete(deleted) error = %v, want ErrNotFound", err)
+	}
+	if _, err := db.Books.Get(ctx, b.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(deleted) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWithTxRollback(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	isbn := "repository-test-" + time.Now().Format(time.RFC3339Nano)
+	errAbort := errors.New("abort")
+	err := db.WithTx(ctx, func(tx *Tx) error {
+		if _, err := tx.Books.Create(ctx, Book{Title: "t", Author: "a", Publisher: "p", ISBN: isbn}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx() error = %v, want errAbort", err)
+	}
+
+	if _, err := db.Books.GetByISBN(ctx, isbn); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByISBN() after rollback error = %v, want ErrNotFound", err)
+	}
+}
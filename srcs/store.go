@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"ex_04/repository"
+)
+
+var (
+	ErrNotFound = errors.New("comment not found")
+	ErrConflict = errors.New("comment has been modified by another request")
+)
+
+// CommentStore keeps the comments posted to /comments.
+//
+// Create assigns the ID, version and timestamps. Update replaces the message
+// and user name of an existing comment; when c.Version is not zero it must
+// match the stored version, otherwise ErrConflict is returned.
+type CommentStore interface {
+	List(ctx context.Context, opts ListOptions) ([]Comment, error)
+	Get(ctx context.Context, id int64) (Comment, error)
+	Create(ctx context.Context, c Comment) (Comment, error)
+	Update(ctx context.Context, c Comment) (Comment, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// ListOptions selects at most Limit comments with an ID greater than Cursor,
+// in ascending ID order. An empty UserName matches every user.
+type ListOptions struct {
+	UserName string
+	Cursor   int64
+	Limit    int
+}
+
+type StoreConfig struct {
+	Backend string
+	Path    string
+	DSN     string
+}
+
+func NewCommentStore(cfg StoreConfig) (CommentStore, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryCommentStore(), nil
+	case "file":
+		return NewFileCommentStore(cfg.Path)
+	case "postgres":
+		return NewPostgresCommentStore(cfg.DSN)
+	}
+	return nil, fmt.Errorf("unknown comment store: %q", cfg.Backend)
+}
+
+type MemoryCommentStore struct {
+	mutex    sync.RWMutex
+	comments []Comment // sorted by ID
+	lastID   int64
+}
+
+func NewMemoryCommentStore() *MemoryCommentStore {
+	return &MemoryCommentStore{
+		comments: make([]Comment, 0, 1000),
+	}
+}
+
+func (s *MemoryCommentStore) List(ctx context.Context, opts ListOptions) ([]Comment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	comments := make([]Comment, 0)
+	for i := s.search(opts.Cursor + 1); i < len(s.comments); i++ {
+		if opts.Limit > 0 && len(comments) == opts.Limit {
+			break
+		}
+		if opts.UserName != "" && s.comments[i].UserName != opts.UserName {
+			continue
+		}
+		comments = append(comments, s.comments[i])
+	}
+	return comments, nil
+}
+
+func (s *MemoryCommentStore) Get(ctx context.Context, id int64) (Comment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	i, ok := s.index(id)
+	if !ok {
+		return Comment{}, ErrNotFound
+	}
+	return s.comments[i], nil
+}
+
+func (s *MemoryCommentStore) Create(ctx context.Context, c Comment) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c = s.prepareCreate(c)
+	s.put(c)
+	return c, nil
+}
+
+func (s *MemoryCommentStore) Update(ctx context.Context, c Comment) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, err := s.prepareUpdate(c)
+	if err != nil {
+		return Comment{}, err
+	}
+	s.put(c)
+	return c, nil
+}
+
+func (s *MemoryCommentStore) Delete(ctx context.Context, id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index(id); !ok {
+		return ErrNotFound
+	}
+	s.remove(id)
+	return nil
+}
+
+// The helpers below expect the caller to hold s.mutex.
+
+func (s *MemoryCommentStore) search(id int64) int {
+	return sort.Search(len(s.comments), func(i int) bool {
+		return s.comments[i].ID >= id
+	})
+}
+
+func (s *MemoryCommentStore) index(id int64) (int, bool) {
+	i := s.search(id)
+	return i, i < len(s.comments) && s.comments[i].ID == id
+}
+
+func (s *MemoryCommentStore) prepareCreate(c Comment) Comment {
+	now := time.Now().UTC()
+	s.lastID++
+	c.ID = s.lastID
+	c.Version = 1
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	return c
+}
+
+func (s *MemoryCommentStore) prepareUpdate(c Comment) (Comment, error) {
+	i, ok := s.index(c.ID)
+	if !ok {
+		return Comment{}, ErrNotFound
+	}
+	old := s.comments[i]
+	if c.Version != 0 && c.Version != old.Version {
+		return Comment{}, ErrConflict
+	}
+	c.Version = old.Version + 1
+	c.CreatedAt = old.CreatedAt
+	c.UpdatedAt = time.Now().UTC()
+	return c, nil
+}
+
+func (s *MemoryCommentStore) put(c Comment) {
+	if c.ID > s.lastID {
+		s.lastID = c.ID
+	}
+	i, ok := s.index(c.ID)
+	if ok {
+		s.comments[i] = c
+		return
+	}
+	s.comments = append(s.comments, Comment{})
+	copy(s.comments[i+1:], s.comments[i:])
+	s.comments[i] = c
+}
+
+func (s *MemoryCommentStore) remove(id int64) {
+	if i, ok := s.index(id); ok {
+		s.comments = append(s.comments[:i], s.comments[i+1:]...)
+	}
+}
+
+// FileCommentStore appends every change as one JSON line, and replays the
+// file on open so comments survive restarts. A deletion is written as a
+// tombstone line carrying only the ID.
+type FileCommentStore struct {
+	MemoryCommentStore
+	f *os.File
+}
+
+type fileRecord struct {
+	Comment
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func NewFileCommentStore(path string) (*FileCommentStore, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileCommentStore{
+		MemoryCommentStore: *NewMemoryCommentStore(),
+		f:                  f,
+	}
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec fileRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		switch {
+		case rec.Deleted:
+			s.remove(rec.ID)
+		case rec.ID == 0:
+			// written before comments had IDs
+			s.put(s.prepareCreate(rec.Comment))
+		default:
+			s.put(rec.Comment)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileCommentStore) Create(ctx context.Context, c Comment) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c = s.prepareCreate(c)
+	if err := s.append(fileRecord{Comment: c}); err != nil {
+		s.lastID--
+		return Comment{}, err
+	}
+	s.put(c)
+	return c, nil
+}
+
+func (s *FileCommentStore) Update(ctx context.Context, c Comment) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, err := s.prepareUpdate(c)
+	if err != nil {
+		return Comment{}, err
+	}
+	if err := s.append(fileRecord{Comment: c}); err != nil {
+		return Comment{}, err
+	}
+	s.put(c)
+	return c, nil
+}
+
+func (s *FileCommentStore) Delete(ctx context.Context, id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index(id); !ok {
+		return ErrNotFound
+	}
+	if err := s.append(fileRecord{Comment: Comment{ID: id}, Deleted: true}); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+func (s *FileCommentStore) append(rec fileRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *FileCommentStore) Close() error {
+	return s.f.Close()
+}
+
+// PostgresCommentStore keeps comments in the comments table managed by the
+// repository package.
+type PostgresCommentStore struct {
+	db *repository.DB
+}
+
+func NewPostgresCommentStore(dsn string) (*PostgresCommentStore, error) {
+	db, err := repository.Open(context.Background(), repository.Config{DSN: dsn})
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresCommentStore{db: db}, nil
+}
+
+func fromRepository(c repository.Comment) Comment {
+	return Comment{
+		ID:        c.ID,
+		Message:   c.Message,
+		UserName:  c.UserName,
+		Version:   c.Version,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+func toRepository(c Comment) repository.Comment {
+	return repository.Comment{
+		ID:       c.ID,
+		Message:  c.Message,
+		UserName: c.UserName,
+		Version:  c.Version,
+	}
+}
+
+func postgresError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, repository.ErrConflict):
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *PostgresCommentStore) List(ctx context.Context, opts ListOptions) ([]Comment, error) {
+	rows, err := s.db.Comments.List(ctx, repository.CommentFilter{
+		UserName: opts.UserName,
+		AfterID:  opts.Cursor,
+		Limit:    opts.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	comments := make([]Comment, 0, len(rows))
+	for _, row := range rows {
+		comments = append(comments, fromRepository(row))
+	}
+	return comments, nil
+}
+
+func (s *PostgresCommentStore) Get(ctx context.Context, id int64) (Comment, error) {
+	row, err := s.db.Comments.Get(ctx, id)
+	if err != nil {
+		return Comment{}, postgresError(err)
+	}
+	return fromRepository(row), nil
+}
+
+func (s *PostgresCommentStore) Create(ctx context.Context, c Comment) (Comment, error) {
+	row, err := s.db.Comments.Create(ctx, toRepository(c))
+	if err != nil {
+		return Comment{}, postgresError(err)
+	}
+	return fromRepository(row), nil
+}
+
+func (s *PostgresCommentStore) Update(ctx context.Context, c Comment) (Comment, error) {
+	row, err := s.db.Comments.Update(ctx, toRepository(c))
+	if err != nil {
+		return Comment{}, postgresError(err)
+	}
+	return fromRepository(row), nil
+}
+
+func (s *PostgresCommentStore) Delete(ctx context.Context, id int64) error {
+	return postgresError(s.db.Comments.Delete(ctx, id))
+}
+
+func (s *PostgresCommentStore) Close() error {
+	return s.db.Close()
+}
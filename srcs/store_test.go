@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCommentStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "comments.jsonl")
+
+	s, err := NewFileCommentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.Create(ctx, Comment{Message: "hello", UserName: "gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Create(ctx, Comment{Message: "bye", UserName: "gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Message = "hello again"
+	if _, err := s.Update(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewFileCommentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	got, err := s.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Message != "hello again" || got[0].Version != 2 {
+		t.Errorf("List() = %+v, want only the updated first comment", got)
+	}
+	if _, err := s.Get(ctx, second.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(deleted) error = %v, want ErrNotFound", err)
+	}
+
+	// IDs must not be reused after a restart
+	third, err := s.Create(ctx, Comment{Message: "new", UserName: "gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.ID <= second.ID {
+		t.Errorf("Create() ID = %d, want greater than %d", third.ID, second.ID)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookWarning posts warnings as a Slack-compatible {"text": ...} payload.
+type WebhookWarning struct {
+	URL     string
+	Client  *http.Client
+	Backoff Backoff
+}
+
+func (w *WebhookWarning) Show(message string) {
+	if err := w.Notify(context.Background(), SeverityWarn, message); err != nil {
+		log.Println(err)
+	}
+}
+
+func (w *WebhookWarning) Notify(ctx context.Context, level Severity, message string) error {
+	body, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("[%s] %s: %s", os.Args[0], strings.ToUpper(level.String()), message),
+	})
+	if err != nil {
+		return err
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return w.Backoff.Retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+		if err != nil {
+			return permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, res.Body)
+
+		if res.StatusCode/100 == 2 {
+			return nil
+		}
+		herr := &HTTPError{StatusCode: res.StatusCode, URL: w.URL}
+		if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+			return herr
+		}
+		return permanent(herr)
+	})
+}
+
+// FileWarning appends warnings to Path, rotating it to Path.1, Path.2, ...
+// once it would grow beyond MaxBytes. At most MaxBackups old files are kept.
+type FileWarning struct {
+	Path       string
+	MaxBytes   int64
+	MaxBackups int
+
+	mutex sync.Mutex
+	f     *os.File
+	size  int64
+}
+
+func (w *FileWarning) Show(message string) {
+	if err := w.Notify(context.Background(), SeverityWarn, message); err != nil {
+		log.Println(err)
+	}
+}
+
+func (w *FileWarning) Notify(ctx context.Context, level Severity, message string) error {
+	line := fmt.Sprintf("%s %s %s\n", time.Now().Format(time.RFC3339), strings.ToUpper(level.String()), message)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.MaxBytes > 0 && w.size > 0 && w.size+int64(len(line)) > w.MaxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.f.WriteString(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *FileWarning) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func (w *FileWarning) open() error {
+	f, err := os.OpenFile(w.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = info.Size()
+	return nil
+}
+
+func (w *FileWarning) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+
+	if w.MaxBackups <= 0 {
+		if err := os.Remove(w.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return w.open()
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", w.Path, w.MaxBackups))
+	for i := w.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", w.Path, i), fmt.Sprintf("%s.%d", w.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.Path, w.Path+".1"); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// EmailWarning sends every warning as a plain text mail through Addr.
+// Username and Password are optional; net/smtp only sends them over TLS or
+// to localhost. Each attempt gives up after Timeout, 30 seconds by default,
+// or when ctx is done, whichever comes first.
+type EmailWarning struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+	Timeout  time.Duration
+	Backoff  Backoff
+}
+
+func (w *EmailWarning) Show(message string) {
+	if err := w.Notify(context.Background(), SeverityWarn, message); err != nil {
+		log.Println(err)
+	}
+}
+
+func (w *EmailWarning) Notify(ctx context.Context, level Severity, message string) error {
+	host, _, err := net.SplitHostPort(w.Addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if w.Username != "" {
+		auth = smtp.PlainAuth("", w.Username, w.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", w.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(w.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [%s] %s\r\n", strings.ToUpper(level.String()), os.Args[0])
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	return w.Backoff.Retry(ctx, func() error {
+		err := w.send(ctx, host, auth, msg.Bytes())
+		var terr *textproto.Error
+		if errors.As(err, &terr) && terr.Code >= 500 {
+			// 5xx replies such as 550 (no such mailbox) will not change
+			return permanent(err)
+		}
+		return err
+	})
+}
+
+// send does what smtp.SendMail does, but over a connection that is bounded
+// by ctx and w.Timeout.
+func (w *EmailWarning) send(ctx context.Context, host string, auth smtp.Auth, msg []byte) error {
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", w.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// cancelling ctx also unblocks a session that is in progress
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(w.From); err != nil {
+		return err
+	}
+	for _, to := range w.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// WarningConfig describes one sink in a JSON configuration file. Which of
+// the fields are used depends on Type.
+type WarningConfig struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	MinSeverity string   `json:"min_severity"`
+	RateLimit   int      `json:"rate_limit"`
+	RatePeriod  Duration `json:"rate_period"`
+
+	URL        string   `json:"url"`
+	Path       string   `json:"path"`
+	MaxBytes   int64    `json:"max_bytes"`
+	MaxBackups int      `json:"max_backups"`
+	Network    string   `json:"network"`
+	Addr       string   `json:"addr"`
+	Tag        string   `json:"tag"`
+	From       string   `json:"from"`
+	To         []string `json:"to"`
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	Timeout    Duration `json:"timeout"`
+
+	Attempts       int      `json:"attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+}
+
+// Duration reads a time.Duration from a JSON string such as "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (cfg WarningConfig) backoff() Backoff {
+	b := defaultBackoff
+	if cfg.Attempts > 0 {
+		b.Attempts = cfg.Attempts
+	}
+	if cfg.InitialBackoff > 0 {
+		b.Initial = time.Duration(cfg.InitialBackoff)
+	}
+	if cfg.MaxBackoff > 0 {
+		b.Max = time.Duration(cfg.MaxBackoff)
+	}
+	return b
+}
+
+func NewWarning(cfg WarningConfig) (Warning, error) {
+	switch cfg.Type {
+	case "console":
+		return ConsoleWarning{}, nil
+	case "desktop":
+		return DesktopWarning{}, nil
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("%s: url is required", cfg.Name)
+		}
+		return &WebhookWarning{URL: cfg.URL, Backoff: cfg.backoff()}, nil
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("%s: path is required", cfg.Name)
+		}
+		return &FileWarning{Path: cfg.Path, MaxBytes: cfg.MaxBytes, MaxBackups: cfg.MaxBackups}, nil
+	case "syslog":
+		return &SyslogWarning{Network: cfg.Network, Addr: cfg.Addr, Tag: cfg.Tag, Backoff: cfg.backoff()}, nil
+	case "email":
+		if cfg.Addr == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("%s: addr, from and to are required", cfg.Name)
+		}
+		return &EmailWarning{
+			Addr:     cfg.Addr,
+			From:     cfg.From,
+			To:       cfg.To,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			Timeout:  time.Duration(cfg.Timeout),
+			Backoff:  cfg.backoff(),
+		}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown warning type: %q", cfg.Name, cfg.Type)
+}
+
+// LoadNotifier builds a Notifier from a JSON array of WarningConfig.
+func LoadNotifier(path string, opts ...NotifierOption) (*Notifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfgs []WarningConfig
+	if err := json.NewDecoder(f).Decode(&cfgs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, cfg := range cfgs {
+		w, err := NewWarning(cfg)
+		if err != nil {
+			return nil, err
+		}
+		min := SeverityInfo
+		if cfg.MinSeverity != "" {
+			if min, err = ParseSeverity(cfg.MinSeverity); err != nil {
+				return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+			}
+		}
+		name := cfg.Name
+		if name == "" {
+			name = cfg.Type
+		}
+		opts = append(opts, WithSink(Sink{
+			Name:        name,
+			Warning:     w,
+			MinSeverity: min,
+			RateLimit:   cfg.RateLimit,
+			RatePeriod:  time.Duration(cfg.RatePeriod),
+		}))
+	}
+	return NewNotifier(opts...), nil
+}
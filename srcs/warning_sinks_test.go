@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testBackoff = Backoff{Attempts: 3, Initial: time.Millisecond, Max: time.Millisecond}
+
+func TestWebhookWarningRetry(t *testing.T) {
+	var calls int32
+	texts := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		texts <- payload["text"]
+	}))
+	defer srv.Close()
+
+	w := &WebhookWarning{URL: srv.URL, Backoff: testBackoff}
+	if err := w.Notify(context.Background(), SeverityError, "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	if n, text := atomic.LoadInt32(&calls), <-texts; n != 2 || !strings.Contains(text, "ERROR: disk full") {
+		t.Errorf("calls = %d, text = %q", n, text)
+	}
+}
+
+func TestWebhookWarningPermanentError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	w := &WebhookWarning{URL: srv.URL, Backoff: testBackoff}
+	err := w.Notify(context.Background(), SeverityError, "disk full")
+	var herr *HTTPError
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+		t.Errorf("Notify() = %v, want a 404 HTTPError", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("calls = %d, a 404 must not be retried", n)
+	}
+}
+
+func TestFileWarningRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warnings.log")
+	w := &FileWarning{Path: path, MaxBytes: 64, MaxBackups: 2}
+	defer w.Close()
+
+	for i := 0; i < 6; i++ {
+		if err := w.Notify(context.Background(), SeverityWarn, "this line is about forty bytes"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s: %v", filepath.Base(name), err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than MaxBackups files are kept")
+	}
+}
+
+func TestSyslogWarning(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("no syslog on " + runtime.GOOS)
+	}
+
+	addr := filepath.Join(t.TempDir(), "syslog.sock")
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+
+	w := &SyslogWarning{Network: "unixgram", Addr: addr, Tag: "test"}
+	defer w.Close()
+	if err := w.Notify(context.Background(), SeverityCritical, "on fire"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// <10> is LOG_USER|LOG_CRIT
+	if got := string(buf[:n]); !strings.HasPrefix(got, "<10>") || !strings.Contains(got, "on fire") {
+		t.Errorf("syslog message = %q", got)
+	}
+}
+
+// serveSMTP accepts one SMTP session on l and sends the DATA it receives.
+func serveSMTP(l net.Listener, data chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			reply("235 accepted")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var body strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				body.WriteString(l)
+			}
+			data <- body.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmailWarning(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	data := make(chan string, 1)
+	go serveSMTP(l, data)
+
+	w := &EmailWarning{Addr: l.Addr().String(), From: "alert@example.com", To: []string{"oncall@example.com"}}
+	if err := w.Notify(context.Background(), SeverityCritical, "database is down"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-data:
+		if !strings.Contains(got, "Subject: [CRITICAL]") || !strings.Contains(got, "database is down") {
+			t.Errorf("mail = %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+}
+
+func TestEmailWarningIPv6(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer l.Close()
+
+	data := make(chan string, 1)
+	go serveSMTP(l, data)
+
+	// net/smtp only sends a password in the clear to localhost, which it
+	// recognizes by the host taken from Addr
+	w := &EmailWarning{
+		Addr:     l.Addr().String(),
+		From:     "alert@example.com",
+		To:       []string{"oncall@example.com"},
+		Username: "alert",
+		Password: "secret",
+	}
+	if err := w.Notify(context.Background(), SeverityCritical, "database is down"); err != nil {
+		t.Fatal(err)
+	}
+	<-data
+}
+
+func TestEmailWarningPermanentError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var sessions int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&sessions, 1)
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write([]byte("220 localhost ESMTP\r\n"))
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(strings.ToUpper(line), "RCPT") {
+						conn.Write([]byte("550 no such user\r\n"))
+					} else {
+						conn.Write([]byte("250 ok\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	w := &EmailWarning{Addr: l.Addr().String(), From: "alert@example.com", To: []string{"nobody@example.com"}, Backoff: testBackoff}
+	if err := w.Notify(context.Background(), SeverityError, "disk full"); err == nil {
+		t.Fatal("Notify() succeeded against a 550 reply")
+	}
+	if n := atomic.LoadInt32(&sessions); n != 1 {
+		t.Errorf("sessions = %d, want 1", n)
+	}
+}
+
+func TestEmailWarningTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// accept but never greet
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	w := &EmailWarning{Addr: l.Addr().String(), From: "alert@example.com", To: []string{"oncall@example.com"}, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	if err := w.Notify(context.Background(), SeverityError, "disk full"); err == nil {
+		t.Fatal("Notify() succeeded against a stalled server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Notify() took %v", d)
+	}
+
+	w.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := w.Notify(ctx, SeverityError, "disk full"); err == nil {
+		t.Fatal("Notify() succeeded against a stalled server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Notify() ignored ctx, took %v", d)
+	}
+}
+
+func TestLoadNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warnings.json")
+	config := `[
+		{"type": "console", "min_severity": "error"},
+		{"name": "log", "type": "file", "path": "` + filepath.Join(t.TempDir(), "w.log") + `", "rate_limit": 5, "rate_period": "1m"}
+	]`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := LoadNotifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.sinks) != 2 || n.sinks[0].MinSeverity != SeverityError || n.sinks[1].RatePeriod != time.Minute {
+		t.Errorf("sinks = %+v", n.sinks)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"type": "webhook"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNotifier(path); err == nil {
+		t.Error("LoadNotifier() accepted a webhook without url")
+	}
+}
@@ -0,0 +1,73 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"log"
+	"log/syslog"
+	"sync"
+)
+
+// SyslogWarning writes to the local syslog socket, or to a remote syslog
+// daemon when Network and Addr are set.
+type SyslogWarning struct {
+	Network string
+	Addr    string
+	Tag     string
+	Backoff Backoff
+
+	mutex sync.Mutex
+	w     *syslog.Writer
+}
+
+func (w *SyslogWarning) Show(message string) {
+	if err := w.Notify(context.Background(), SeverityWarn, message); err != nil {
+		log.Println(err)
+	}
+}
+
+func (w *SyslogWarning) Notify(ctx context.Context, level Severity, message string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.Backoff.Retry(ctx, func() error {
+		if w.w == nil {
+			sw, err := syslog.Dial(w.Network, w.Addr, syslog.LOG_WARNING|syslog.LOG_USER, w.Tag)
+			if err != nil {
+				return err
+			}
+			w.w = sw
+		}
+
+		var err error
+		switch level {
+		case SeverityInfo:
+			err = w.w.Info(message)
+		case SeverityWarn:
+			err = w.w.Warning(message)
+		case SeverityError:
+			err = w.w.Err(message)
+		default:
+			err = w.w.Crit(message)
+		}
+		if err != nil {
+			// dial again on the next attempt
+			w.w.Close()
+			w.w = nil
+		}
+		return err
+	})
+}
+
+func (w *SyslogWarning) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.w == nil {
+		return nil
+	}
+	err := w.w.Close()
+	w.w = nil
+	return err
+}
@@ -0,0 +1,26 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// SyslogWarning is not available on this platform; Notify always fails.
+type SyslogWarning struct {
+	Network string
+	Addr    string
+	Tag     string
+	Backoff Backoff
+}
+
+func (w *SyslogWarning) Show(message string) {}
+
+func (w *SyslogWarning) Notify(ctx context.Context, level Severity, message string) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+func (w *SyslogWarning) Close() error {
+	return nil
+}